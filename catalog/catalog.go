@@ -0,0 +1,356 @@
+// Package catalog writes a browsable overview of a game's shapes and creatures: an html page and png contact sheets.
+package catalog
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uzudil/isongn/shapes"
+)
+
+// contact sheets are filled in rows up to this width
+const sheetWidth = 1024
+const cellPadding = 4
+
+type cell struct {
+	Sheet      string
+	X, Y, W, H int
+}
+
+type shapeEntry struct {
+	Thumb  cell
+	Name   string
+	Index  int
+	Size   string
+	Group  int
+	Flags  string
+	Props  string
+	Edges  []edgeEntry
+	Hidden bool
+}
+
+type edgeEntry struct {
+	Target string
+	Dir    string
+	Names  string
+}
+
+type sheetEntry struct {
+	Image  string
+	Shapes []*shapeEntry
+}
+
+type creatureEntry struct {
+	Name       string
+	Index      int
+	Size       string
+	Flags      string
+	Props      string
+	Animations []*animationEntry
+}
+
+type animationEntry struct {
+	Name string
+	Mode string
+	Dirs []*dirEntry
+}
+
+type dirEntry struct {
+	Dir    string
+	Frames []cell
+}
+
+// contactSheet places images left to right in rows, like the atlas does
+type contactSheet struct {
+	name          string
+	images        []image.Image
+	cells         []*cell
+	x, y, shelfH  int
+	width, height int
+}
+
+func (sheet *contactSheet) add(img image.Image) cell {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if sheet.x > 0 && sheet.x+w+cellPadding > sheetWidth {
+		sheet.x = 0
+		sheet.y += sheet.shelfH
+		sheet.shelfH = 0
+	}
+	c := &cell{sheet.name, sheet.x, sheet.y, w, h}
+	sheet.images = append(sheet.images, img)
+	sheet.cells = append(sheet.cells, c)
+	sheet.x += w + cellPadding
+	if h+cellPadding > sheet.shelfH {
+		sheet.shelfH = h + cellPadding
+	}
+	if sheet.x > sheet.width {
+		sheet.width = sheet.x
+	}
+	if sheet.y+sheet.shelfH > sheet.height {
+		sheet.height = sheet.y + sheet.shelfH
+	}
+	return *c
+}
+
+func (sheet *contactSheet) save(outDir string) error {
+	if len(sheet.images) == 0 {
+		return nil
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, sheet.width, sheet.height))
+	for i, img := range sheet.images {
+		c := sheet.cells[i]
+		draw.Draw(rgba, image.Rect(c.X, c.Y, c.X+c.W, c.Y+c.H), img, img.Bounds().Min, draw.Src)
+	}
+	f, err := os.Create(filepath.Join(outDir, sheet.name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, rgba)
+}
+
+// Write loads the shapes and creatures and writes the catalog to outDir.
+// It only needs the images, not a window, so it runs before any graphics are set up.
+func Write(gameDir, outDir string, sheets []shapes.SheetConfig, creatures []shapes.CreatureConfig) error {
+	if err := shapes.InitShapes(gameDir, sheets); err != nil {
+		return err
+	}
+	if err := shapes.InitCreatures(gameDir, creatures); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	contactSheets := []*contactSheet{}
+	newSheet := func(prefix string, i int) *contactSheet {
+		sheet := &contactSheet{name: fmt.Sprintf("%s%d.png", prefix, i)}
+		contactSheets = append(contactSheets, sheet)
+		return sheet
+	}
+
+	// shapes are grouped by the image they were cut from
+	sheetEntries := make([]*sheetEntry, len(sheets))
+	sheetContacts := map[int]*contactSheet{}
+	creatureEntries := []*creatureEntry{}
+	for _, shape := range shapes.Shapes {
+		if shape == nil {
+			continue
+		}
+		if len(shape.Animations) > 0 {
+			creatureEntries = append(creatureEntries, creatureEntryFor(shape, newSheet("creature", len(creatureEntries))))
+			continue
+		}
+		// InitShapes adds one image per sheet, in order
+		i := shape.ImageIndex
+		if sheetEntries[i] == nil {
+			sheetEntries[i] = &sheetEntry{Image: sheets[i].Image}
+			sheetContacts[i] = newSheet("shapes", i)
+		}
+		sheetEntries[i].Shapes = append(sheetEntries[i].Shapes, shapeEntryFor(shape, sheetContacts[i]))
+	}
+
+	for _, sheet := range contactSheets {
+		if err := sheet.save(outDir); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(filepath.Join(outDir, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = page.Execute(f, map[string]interface{}{
+		"Game":      filepath.Base(gameDir),
+		"Sheets":    sheetEntries,
+		"Creatures": creatureEntries,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Catalog of %d shapes written to %s\n", len(shapes.Names), outDir)
+	return nil
+}
+
+func shapeEntryFor(shape *shapes.Shape, sheet *contactSheet) *shapeEntry {
+	entry := &shapeEntry{
+		Thumb:  sheet.add(shape.Image),
+		Name:   shape.Name,
+		Index:  shape.Index,
+		Size:   formatSize(shape.Size),
+		Group:  shape.Group,
+		Flags:  formatFlags(shape),
+		Props:  formatProps(shape.Props),
+		Hidden: !shape.EditorVisible,
+	}
+	for _, target := range sortedKeys(shape.Edges) {
+		dirs := shape.Edges[target]
+		dirNames := []string{}
+		for dir := range dirs {
+			dirNames = append(dirNames, dir)
+		}
+		sort.Strings(dirNames)
+		for _, dir := range dirNames {
+			names := []string{}
+			for _, edge := range dirs[dir] {
+				names = append(names, edge.Name)
+			}
+			entry.Edges = append(entry.Edges, edgeEntry{target, dir, strings.Join(names, ", ")})
+		}
+	}
+	return entry
+}
+
+func creatureEntryFor(shape *shapes.Shape, sheet *contactSheet) *creatureEntry {
+	entry := &creatureEntry{
+		Name:  shape.Name,
+		Index: shape.Index,
+		Size:  formatSize(shape.Size),
+		Flags: formatFlags(shape),
+		Props: formatProps(shape.Props),
+	}
+	img := shapes.Images[shape.ImageIndex]
+	animationIndexes := []int{}
+	for index := range shape.Animations {
+		animationIndexes = append(animationIndexes, index)
+	}
+	sort.Ints(animationIndexes)
+	for _, index := range animationIndexes {
+		animation := shape.Animations[index]
+		a := &animationEntry{Name: animation.Name, Mode: modeName(animation.Mode)}
+		for dir := shapes.DIR_W; dir <= shapes.DIR_NONE; dir++ {
+			steps, ok := animation.Tex[dir]
+			if !ok {
+				continue
+			}
+			d := &dirEntry{Dir: dirName(dir)}
+			for _, tex := range steps {
+				r := image.Rect(int(tex.PixelOffset[0]), int(tex.PixelOffset[1]), int(tex.PixelOffset[0]+tex.PixelDim[0]), int(tex.PixelOffset[1]+tex.PixelDim[1]))
+				frame := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+				draw.Draw(frame, frame.Bounds(), img, r.Min, draw.Src)
+				d.Frames = append(d.Frames, sheet.add(frame))
+			}
+			a.Dirs = append(a.Dirs, d)
+		}
+		entry.Animations = append(entry.Animations, a)
+	}
+	return entry
+}
+
+func formatSize(size [3]float32) string {
+	return fmt.Sprintf("%gx%gx%g", size[0], size[1], size[2])
+}
+
+func formatFlags(shape *shapes.Shape) string {
+	flags := []string{}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"sway", shape.SwayEnabled},
+		{"bob", shape.BobEnabled},
+		{"breathe", shape.BreatheEnabled},
+		{"nosupport", shape.NoSupport},
+		{"extra", shape.IsExtra},
+		{"emissive", shape.Emissive},
+		{"light", shape.Light != nil},
+	} {
+		if f.on {
+			flags = append(flags, f.name)
+		}
+	}
+	return strings.Join(flags, " ")
+}
+
+func formatProps(props map[string]interface{}) string {
+	parts := []string{}
+	for _, k := range sortedKeys(props) {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, props[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch t := m.(type) {
+	case map[string]interface{}:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	case map[string]map[string][]*shapes.Shape:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func dirName(dir shapes.Direction) string {
+	for name, d := range shapes.Directions {
+		if d == dir && name != "" {
+			return name
+		}
+	}
+	return "none"
+}
+
+func modeName(mode shapes.AnimationMode) string {
+	for name, m := range shapes.AnimationModes {
+		if m == mode && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+var page = template.Must(template.New("catalog").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Game}} shapes</title>
+<style>
+body { font-family: sans-serif; background: #333; color: #ddd; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #555; padding: 4px 8px; vertical-align: top; text-align: left; }
+.thumb { background-repeat: no-repeat; }
+.hidden { opacity: 0.6; }
+.frames div { display: inline-block; margin-right: 2px; }
+</style>
+</head>
+<body>
+<h1>{{.Game}}</h1>
+{{range .Sheets}}{{if .}}
+<h2>{{.Image}}</h2>
+<table>
+<tr><th></th><th>name</th><th>index</th><th>size</th><th>group</th><th>flags</th><th>props</th><th>edges</th></tr>
+{{range .Shapes}}<tr{{if .Hidden}} class="hidden"{{end}}>
+<td><div class="thumb" style="width:{{.Thumb.W}}px;height:{{.Thumb.H}}px;background-image:url('{{.Thumb.Sheet}}');background-position:-{{.Thumb.X}}px -{{.Thumb.Y}}px"></div></td>
+<td>{{.Name}}</td><td>{{.Index}} ({{printf "0x%x" .Index}})</td><td>{{.Size}}</td><td>{{.Group}}</td><td>{{.Flags}}</td><td>{{.Props}}</td>
+<td>{{range .Edges}}{{.Target}} {{.Dir}}: {{.Names}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}{{end}}
+{{if .Creatures}}<h2>creatures</h2>{{end}}
+{{range .Creatures}}
+<h3>{{.Name}}</h3>
+<p>index {{.Index}} ({{printf "0x%x" .Index}}), size {{.Size}}{{if .Flags}}, {{.Flags}}{{end}}{{if .Props}}, {{.Props}}{{end}}</p>
+<table>
+<tr><th>animation</th><th>dir</th><th>frames</th></tr>
+{{range $a := .Animations}}{{range .Dirs}}<tr>
+<td>{{$a.Name}}{{if $a.Mode}} ({{$a.Mode}}){{end}}</td><td>{{.Dir}}</td>
+<td class="frames">{{range .Frames}}<div class="thumb" style="width:{{.W}}px;height:{{.H}}px;background-image:url('{{.Sheet}}');background-position:-{{.X}}px -{{.Y}}px"></div>{{end}}</td>
+</tr>
+{{end}}{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
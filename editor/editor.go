@@ -28,6 +28,7 @@ type Editor struct {
 	lastX, lastY        int
 	updateCursor        bool
 	startX, startY      int
+	watcher             *fileWatcher
 }
 
 func NewEditor(x, y int) *Editor {
@@ -75,6 +76,24 @@ func (e *Editor) Init(app *gfx.App, config map[string]interface{}) {
 	// add a ui
 	e.app.Ui.Add(int(e.app.Width)-150, 0, 150, int(e.app.Height), e.shapeSelectorContents)
 	e.app.Ui.Add(0, 0, int(e.app.Width)-150, 50, e.infoContents)
+
+	// reload shapes when the artists change them
+	e.watcher = newFileWatcher(e.watchedPaths()...)
+}
+
+func (e *Editor) watchedPaths() []string {
+	gameDir := e.app.Config.GameDir
+	paths := []string{
+		filepath.Join(gameDir, "config.json"),
+		filepath.Join(gameDir, "images"),
+		filepath.Join(gameDir, "creatures"),
+	}
+	// the dirs of the included definition files, so new files are noticed too
+	include := e.app.Config.Include
+	for _, pattern := range append(include.Shapes, include.Creatures...) {
+		paths = append(paths, filepath.Dir(filepath.Join(gameDir, pattern)))
+	}
+	return paths
 }
 
 func (e *Editor) Name() string {
@@ -110,6 +129,9 @@ func (e *Editor) isMoveKey() (int, int, bool) {
 }
 
 func (e *Editor) Events(delta float64, fadeDir int) {
+	if changes := e.watcher.changed(delta); len(changes) > 0 {
+		e.reloadShapes(changes)
+	}
 
 	if e.app.Loader.X != e.lastX || e.app.Loader.Y != e.lastY || e.updateCursor {
 		// e.Z = e.findTop(e.app.Loader.X, e.app.Loader.Y)
@@ -200,6 +222,14 @@ func (e *Editor) Events(delta float64, fadeDir int) {
 	if e.app.IsFirstDown(glfw.KeyF) {
 		e.fill()
 	}
+	if e.app.IsFirstDown(glfw.KeyR) {
+		// rotate: switch to the next facing
+		if rotated := shapes.Shapes[e.shapeSelectorIndex].Rotated; rotated != nil {
+			e.shapeSelectorIndex = rotated.Index
+			e.shapeSelectorUpdate = true
+			changed = true
+		}
+	}
 
 	// call bscript
 	e.editorCall.Evaluate(e.ctx)
@@ -216,6 +246,34 @@ func (e *Editor) Events(delta float64, fadeDir int) {
 	}
 }
 
+func (e *Editor) reloadShapes(changes []string) {
+	for _, path := range changes {
+		fmt.Printf("Changed: %s\n", path)
+	}
+	selected := shapes.Shapes[e.shapeSelectorIndex].Name
+	if err := e.app.ReloadShapes(); err != nil {
+		fmt.Printf("Can't reload shapes, keeping the old ones:\n%v\n", err)
+		return
+	}
+	// the include patterns may have changed
+	e.watcher.paths = e.watchedPaths()
+
+	// keep the same shape selected, if it's still there
+	e.shapeSelectorIndex = 0
+	if index, ok := shapes.Names[selected]; ok && shapes.Shapes[index].EditorVisible {
+		e.shapeSelectorIndex = index
+	} else {
+		for i, shape := range shapes.Shapes {
+			if shape != nil && shape.EditorVisible {
+				e.shapeSelectorIndex = i
+				break
+			}
+		}
+	}
+	e.shapeSelectorUpdate = true
+	e.updateCursor = true
+}
+
 func (e *Editor) GetZ() int {
 	return e.Z
 }
@@ -244,7 +302,7 @@ func (e *Editor) fillAt(x, y int, shape, replaceShape *shapes.Shape, seen map[st
 	seen[key] = true
 	shapeIndex, _, _, _, found := e.app.View.GetShape(x, y, 0)
 	fmt.Printf("\tpos=%d,%d found=%v\n", x, y, found)
-	if (replaceShape == nil && found == false) || (replaceShape != nil && int(shapeIndex) == replaceShape.Index) {
+	if (replaceShape == nil && found == false) || (replaceShape != nil && found && shapes.Shapes[shapeIndex].IsVariantOf(replaceShape)) {
 		e.setShape(x, y, 0, shape, false)
 		w := int(shape.Size[0])
 		h := int(shape.Size[1])
@@ -263,10 +321,11 @@ func (e *Editor) setShape(x, y, z int, shape *shapes.Shape, skipEdge bool) {
 		y = (y / h) * h
 		z = 0
 	}
+	placed := shape.PickVariant(x, y, z)
 	if shape.IsExtra {
-		e.app.Loader.AddExtra(x, y, z, e.shapeSelectorIndex)
+		e.app.Loader.AddExtra(x, y, z, placed.Index)
 	} else {
-		e.app.View.SetShape(x, y, z, e.shapeSelectorIndex)
+		e.app.View.SetShape(x, y, z, placed.Index)
 	}
 
 	if skipEdge {
@@ -347,7 +406,8 @@ func (e *Editor) setEdges(x, y int, shape *shapes.Shape) {
 		edgeName = "w"
 	}
 
-	if edgeName != "" && edgeShape.Index != shape.Index {
+	// variants of the same group don't edge each other
+	if edgeName != "" && !edgeShape.IsVariantOf(shape) && !shape.IsVariantOf(edgeShape) {
 		edge := edgeShape.GetEdge(shape.Name, edgeName)
 		if edge != nil {
 			e.app.View.SetEdge(x, y, edge.Index)
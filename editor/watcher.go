@@ -0,0 +1,74 @@
+package editor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// how often to look for changed assets, in seconds
+const watchInterval = 1.0
+
+// fileWatcher polls files and directories (not recursively) for changes.
+type fileWatcher struct {
+	paths  []string
+	mtimes map[string]time.Time
+	timer  float64
+}
+
+func newFileWatcher(paths ...string) *fileWatcher {
+	w := &fileWatcher{paths: paths}
+	w.mtimes = w.scan()
+	return w
+}
+
+func (w *fileWatcher) scan() map[string]time.Time {
+	mtimes := map[string]time.Time{}
+	for _, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			entries, err := ioutil.ReadDir(path)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					mtimes[filepath.Join(path, entry.Name())] = entry.ModTime()
+				}
+			}
+		} else {
+			mtimes[path] = info.ModTime()
+		}
+	}
+	return mtimes
+}
+
+// changed returns the files added, removed or modified since the previous scan.
+func (w *fileWatcher) changed(delta float64) []string {
+	w.timer -= delta
+	if w.timer > 0 {
+		return nil
+	}
+	w.timer = watchInterval
+
+	mtimes := w.scan()
+	changes := []string{}
+	for path, t := range mtimes {
+		if old, ok := w.mtimes[path]; !ok || !old.Equal(t) {
+			changes = append(changes, path)
+		}
+	}
+	for path := range w.mtimes {
+		if _, ok := mtimes[path]; !ok {
+			changes = append(changes, path)
+		}
+	}
+	w.mtimes = mtimes
+	sort.Strings(changes)
+	return changes
+}
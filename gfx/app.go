@@ -1,12 +1,11 @@
 package gfx
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,6 +16,7 @@ import (
 )
 
 const fadeInterval = 0.5
+const maxAtlasSize = 4096
 
 type Game interface {
 	Init(app *App, config map[string]interface{})
@@ -25,6 +25,11 @@ type Game interface {
 	GetZ() int
 }
 
+// AnimationObserver is implemented by games that want to know when a one-shot animation finishes.
+type AnimationObserver interface {
+	AnimationDone(worldX, worldY, worldZ int, name string)
+}
+
 type KeyPress struct {
 	Key      glfw.Key
 	Scancode int
@@ -33,22 +38,6 @@ type KeyPress struct {
 	First    bool
 }
 
-type AppConfig struct {
-	GameDir    string
-	Title      string
-	Name       string
-	Version    float64
-	ViewSize   int
-	ViewSizeZ  int
-	SectorSize int
-	runtime    map[string]interface{}
-	zoom       float64
-	camera     [3]float32
-	shear      [3]float32
-	shapes     []map[string]interface{}
-	creatures  []map[string]interface{}
-}
-
 type App struct {
 	Game                            Game
 	Font                            *Font
@@ -59,6 +48,7 @@ type App struct {
 	lastUpdate                      float64
 	nbFrames                        int
 	View                            *View
+	Camera                          *Camera
 	Ui                              *Ui
 	Dir                             string
 	Loader                          *world.Loader
@@ -79,8 +69,12 @@ func NewApp(game Game, gameDir string, windowWidth, windowHeight int, targetFps
 	if _, err := os.Stat(mapDir); os.IsNotExist(err) {
 		os.Mkdir(mapDir, os.ModePerm)
 	}
-	appConfig := parseConfig(gameDir)
-	width, height := getResolution(appConfig, game.Name())
+	appConfig, err := parseConfig(gameDir, game.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	runtimeConfig := appConfig.runtime[game.Name()]
+	width, height := runtimeConfig.Resolution[0], runtimeConfig.Resolution[1]
 	app := &App{
 		Game:         game,
 		Config:       appConfig,
@@ -91,7 +85,7 @@ func NewApp(game Game, gameDir string, windowWidth, windowHeight int, targetFps
 		windowWidth:  windowWidth,
 		windowHeight: windowHeight,
 	}
-	font, err := NewFont(filepath.Join(gameDir, getFont(appConfig, game.Name())), getFontSize(appConfig, game.Name()))
+	font, err := NewFont(filepath.Join(gameDir, runtimeConfig.Font), runtimeConfig.FontSize)
 	if err != nil {
 		panic(err)
 	}
@@ -108,16 +102,17 @@ func NewApp(game Game, gameDir string, windowWidth, windowHeight int, targetFps
 	app.Window.SetScrollCallback(app.MouseScroll)
 	app.frameBuffer = NewFrameBuffer(int32(width), int32(height), true)
 	app.uiFrameBuffer = NewFrameBuffer(int32(width), int32(height), false)
-	err = shapes.InitShapes(gameDir, appConfig.shapes)
+	err = app.loadShapes(appConfig)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	err = shapes.InitCreatures(gameDir, appConfig.creatures)
+	app.Loader = world.NewLoader(game.(world.WorldObserver), app.Dir, gameDir)
+	app.View = InitView(appConfig.zoom, appConfig.camera, appConfig.shear, app.Loader, gameDir)
+	err = app.View.initParticles(appConfig.particles)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	app.Loader = world.NewLoader(game.(world.WorldObserver), app.Dir, gameDir)
-	app.View = InitView(appConfig.zoom, appConfig.camera, appConfig.shear, app.Loader)
+	app.Camera = NewCamera(app)
 	app.Ui = InitUi(width, height)
 	return app
 }
@@ -130,85 +125,6 @@ func (app *App) GetScreenPos(x, y, z int) (int, int) {
 	return -1000, -1000
 }
 
-func parseConfig(gameDir string) *AppConfig {
-	configPath := filepath.Join(gameDir, "config.json")
-	bytes, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		panic(err)
-	}
-	data := map[string]interface{}{}
-	err = json.Unmarshal(bytes, &data)
-	if err != nil {
-		panic(err)
-	}
-
-	view := data["view"].(map[string]interface{})
-	camera := view["camera"].([]interface{})
-	shear := view["shear"].([]interface{})
-	config := &AppConfig{
-		GameDir:    gameDir,
-		Title:      data["title"].(string),
-		Name:       strings.ToLower(data["name"].(string)),
-		Version:    data["version"].(float64),
-		ViewSize:   int(view["size"].(float64)),
-		ViewSizeZ:  int(view["sizeZ"].(float64)),
-		SectorSize: int(view["sector"].(float64)),
-		runtime:    data["runtime"].(map[string]interface{}),
-		zoom:       view["zoom"].(float64),
-		camera:     [3]float32{float32(camera[0].(float64)), float32(camera[1].(float64)), float32(camera[2].(float64))},
-		shear:      [3]float32{float32(shear[0].(float64)), float32(shear[1].(float64)), float32(shear[2].(float64))},
-		shapes:     toMap(data["shapes"].([]interface{})),
-		creatures:  toMap(data["creatures"].([]interface{})),
-	}
-	fmt.Printf("Starting game: %s (v%f)\n", config.Title, config.Version)
-	return config
-}
-
-func toMap(a []interface{}) []map[string]interface{} {
-	r := []map[string]interface{}{}
-	for _, o := range a {
-		r = append(r, o.(map[string]interface{}))
-	}
-	return r
-}
-
-func getResolution(appConfig *AppConfig, mode string) (int, int) {
-	runtimeConfig, ok := appConfig.runtime[mode]
-	if ok == false {
-		panic("Can't find runtime config")
-	}
-	resolution, ok := (runtimeConfig.(map[string]interface{}))["resolution"]
-	if ok == false {
-		panic("Can't find resolution in runtime config")
-	}
-	resArray := (resolution.([]interface{}))
-	return int(resArray[0].(float64)), int(resArray[1].(float64))
-}
-
-func getFontSize(appConfig *AppConfig, mode string) int {
-	runtimeConfig, ok := appConfig.runtime[mode]
-	if ok == false {
-		panic("Can't find runtime config")
-	}
-	fontSize, ok := (runtimeConfig.(map[string]interface{}))["fontSize"]
-	if ok == false {
-		panic("Can't find fontSize in runtime config")
-	}
-	return int(fontSize.(float64))
-}
-
-func getFont(appConfig *AppConfig, mode string) string {
-	runtimeConfig, ok := appConfig.runtime[mode]
-	if ok == false {
-		panic("Can't find runtime config")
-	}
-	font, ok := (runtimeConfig.(map[string]interface{}))["font"]
-	if ok == false {
-		panic("Can't find fontSize in runtime config")
-	}
-	return font.(string)
-}
-
 func initUserdir(gameName string) string {
 	// create user dir if needed
 	userHomeDir, err := os.UserHomeDir()
@@ -246,6 +162,73 @@ func initWindow(windowWidth, windowHeight int) *glfw.Window {
 	return window
 }
 
+func (app *App) loadShapes(appConfig *AppConfig) error {
+	err := shapes.InitShapes(appConfig.GameDir, appConfig.shapes)
+	if err != nil {
+		return err
+	}
+	err = shapes.InitCreatures(appConfig.GameDir, appConfig.creatures)
+	if err != nil {
+		return err
+	}
+	return shapes.PackAtlas(filepath.Join(app.Dir, "atlas"), atlasSize())
+}
+
+// ReloadShapes re-reads the shape and creature definitions with their images and rebuilds the view.
+// Shapes keep their index as long as their sheet's id and their position in it don't change, creatures
+// as long as their id doesn't.
+// Maps save these indexes, so a reload which moves or removes a shape is refused: restart instead.
+// On error, nothing changes.
+func (app *App) ReloadShapes() error {
+	appConfig, err := parseConfig(app.Config.GameDir, app.Game.Name())
+	if err != nil {
+		return err
+	}
+	oldNames := shapes.Names
+	err = shapes.Reload(func() error {
+		if err := app.loadShapes(appConfig); err != nil {
+			return err
+		}
+		return movedShapes(oldNames, shapes.Names)
+	})
+	if err != nil {
+		return err
+	}
+	app.Config.Include = appConfig.Include
+	app.Config.shapes = appConfig.shapes
+	app.Config.creatures = appConfig.creatures
+	app.View.ReloadBlocks()
+	app.View.Reload()
+	return nil
+}
+
+// movedShapes lists the shapes whose index would change, as maps would then show the wrong shapes
+func movedShapes(oldNames, newNames map[string]int) error {
+	moved := []string{}
+	for name, index := range oldNames {
+		if newIndex, ok := newNames[name]; !ok {
+			moved = append(moved, fmt.Sprintf("%s was removed, maps may still use index %d", name, index))
+		} else if newIndex != index {
+			moved = append(moved, fmt.Sprintf("%s would move from index %d to %d", name, index, newIndex))
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	sort.Strings(moved)
+	return fmt.Errorf("shapes would change index, restart to apply:\n\t%s", strings.Join(moved, "\n\t"))
+}
+
+// the largest atlas page the gpu can hold, capped to keep texture memory reasonable
+func atlasSize() int {
+	var size int32
+	gl.GetIntegerv(gl.MAX_TEXTURE_SIZE, &size)
+	if size > maxAtlasSize {
+		return maxAtlasSize
+	}
+	return int(size)
+}
+
 func (app *App) IsDown(key glfw.Key) bool {
 	_, ok := app.KeyState[key]
 	return ok
@@ -331,7 +314,7 @@ func (app *App) Sleep(lastTime float64) (float64, float64) {
 }
 
 func (app *App) Run() {
-	app.Game.Init(app, app.Config.runtime[app.Game.Name()].(map[string]interface{}))
+	app.Game.Init(app, app.Config.runtime[app.Game.Name()].values)
 
 	// Configure global settings
 	gl.Enable(gl.DEPTH_TEST)
@@ -352,10 +335,20 @@ func (app *App) Run() {
 
 		app.incrFade(last)
 
+		app.Camera.Update(delta)
+		app.View.Update(delta)
+
 		app.frameBuffer.Enable(app.Width, app.Height)
 		app.View.Draw(delta)
 		app.frameBuffer.Draw(app.windowWidthDpi, app.windowHeightDpi, app.fade)
 
+		events := app.View.AnimationEvents()
+		if observer, ok := app.Game.(AnimationObserver); ok {
+			for _, e := range events {
+				observer.AnimationDone(e.X, e.Y, e.Z, e.Name)
+			}
+		}
+
 		app.uiFrameBuffer.Enable(app.Width, app.Height)
 		app.Ui.Draw()
 		app.uiFrameBuffer.Draw(app.windowWidthDpi, app.windowHeightDpi, app.fade)
@@ -0,0 +1,25 @@
+package gfx
+
+import "testing"
+
+func TestMovedShapes(t *testing.T) {
+	old := map[string]int{"floor": 0, "wall": 1, "man": 0x10000}
+	tests := []struct {
+		name  string
+		names map[string]int
+		err   bool
+	}{
+		{"same", map[string]int{"floor": 0, "wall": 1, "man": 0x10000}, false},
+		{"added", map[string]int{"floor": 0, "wall": 1, "door": 2, "man": 0x10000}, false},
+		{"moved", map[string]int{"floor": 0, "wall": 2, "man": 0x10000}, true},
+		{"removed", map[string]int{"floor": 0, "man": 0x10000}, true},
+		{"swapped", map[string]int{"floor": 1, "wall": 0, "man": 0x10000}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := movedShapes(old, test.names); (err != nil) != test.err {
+				t.Errorf("got error %v, expected one: %v", err, test.err)
+			}
+		})
+	}
+}
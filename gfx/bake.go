@@ -0,0 +1,249 @@
+package gfx
+
+import (
+	"sort"
+
+	"github.com/go-gl/gl/all-core/gl"
+	"github.com/uzudil/isongn/shapes"
+	"github.com/uzudil/isongn/util"
+	"github.com/uzudil/isongn/world"
+)
+
+// Shapes which don't move, animate or sway are baked into one vertex buffer per texture for each region
+// of the map. A region is only baked again when something in it changes. The vertices are in world
+// coordinates, so moving the view doesn't change them.
+const REGION_SIZE = 16
+
+// position xyz, texture coords, face normal xyz, height, alphaMin, uniqueOffset, flags, tint rgba
+const BAKED_FLOATS = 16
+
+type regionKey [2]int
+
+type region struct {
+	key     regionKey
+	dirty   bool
+	batches []*bakedBatch
+}
+
+type bakedKey struct {
+	texture *Texture
+	palette *Palette
+}
+
+type bakedBatch struct {
+	bakedKey
+	vbo   uint32
+	count int32
+	data  []float32
+}
+
+func regionOf(worldX, worldY int) regionKey {
+	return regionKey{worldX / REGION_SIZE, worldY / REGION_SIZE}
+}
+
+func isStaticShape(shape *shapes.Shape) bool {
+	return len(shape.Animations) == 0 && !shape.SwayEnabled && !shape.BobEnabled && !shape.BreatheEnabled
+}
+
+// isBakedShape is true for the blocks which go in the baked regions: the static ones without
+// translucent texels, which have to be drawn in order
+func isBakedShape(block *Block) bool {
+	return isStaticShape(block.shape) && !block.translucent
+}
+
+// isBakedCell is true if the shape of the cell goes in its region's baked buffer. Whatever changes
+// the answer has to invalidate the region, or the shape is drawn twice or not at all.
+func isBakedCell(blockPos *BlockPos) bool {
+	if blockPos.block == nil || !isBakedShape(blockPos.block) || blockPos.xray || blockPos.ScrollOffset != ZERO_OFFSET {
+		return false
+	}
+	return blockPos.look == nil || blockPos.look.Tint[3] == 1
+}
+
+// drawnAt calls fx with what is drawn at a view position: the shape, its extras and at z 0 the edge,
+// unless the column is cut below z. bake draws the baked ones, buildInstances the others.
+func (view *View) drawnAt(x, y, z int, fx func(b *BlockPos, extraIndex int, block *Block, z float32, baked bool)) {
+	if z >= view.topZ(x, y) {
+		return
+	}
+	blockPos := view.at(x, y, z)
+	if blockPos.block != nil {
+		fx(blockPos, -1, blockPos.block, blockPos.model.At(2, 3), isBakedCell(blockPos))
+	}
+	for i := 0; i < EXTRA_SIZE && blockPos.extras[i] != nil; i++ {
+		// show extras slightly on top of each other
+		fx(blockPos, i, blockPos.extras[i], blockPos.model.At(2, 3)+float32(i)*0.01, isBakedShape(blockPos.extras[i]))
+	}
+	if z == 0 {
+		edge := view.edgeAt(x, y)
+		if edge.block != nil {
+			fx(edge, -1, edge.block, edge.model.At(2, 3), isBakedShape(edge.block))
+		}
+	}
+}
+
+func (view *View) initBaking() {
+	gl.GenVertexArrays(1, &view.bakedVao)
+	gl.BindVertexArray(view.bakedVao)
+	for _, attrib := range []uint32{view.vertAttrib, view.texCoordAttrib, view.normalAttrib, view.instanceParamsAttrib, view.instanceTintAttrib} {
+		gl.EnableVertexAttribArray(attrib)
+	}
+	view.regions = map[regionKey]*region{}
+}
+
+// invalidate marks the region containing the world position to be baked again
+func (view *View) invalidate(worldX, worldY int) {
+	key := regionOf(worldX, worldY)
+	r, ok := view.regions[key]
+	if !ok {
+		r = &region{key: key}
+		view.regions[key] = r
+	}
+	if !r.dirty {
+		r.dirty = true
+		view.dirty = true
+	}
+}
+
+func (view *View) invalidateAll() {
+	for _, r := range view.regions {
+		r.dirty = true
+	}
+	view.dirty = true
+}
+
+func (view *View) deleteRegions() {
+	for _, r := range view.regions {
+		r.deleteBatches()
+	}
+	view.regions = map[regionKey]*region{}
+}
+
+func (r *region) deleteBatches() {
+	for _, b := range r.batches {
+		gl.DeleteBuffers(1, &b.vbo)
+	}
+	r.batches = nil
+}
+
+// viewBounds is the part of the region which is in the view grid, in view coordinates
+func (view *View) viewBounds(r *region) (int, int, int, int) {
+	x0, y0, _, _ := view.toViewPos(r.key[0]*REGION_SIZE, r.key[1]*REGION_SIZE, 0)
+	return clampView(x0), clampView(y0), clampView(x0 + REGION_SIZE), clampView(y0 + REGION_SIZE)
+}
+
+// bakeRegions bakes the changed regions and forgets the ones which scrolled out of the grid
+func (view *View) bakeRegions() {
+	for key, r := range view.regions {
+		x0, y0, x1, y1 := view.viewBounds(r)
+		if x0 >= x1 || y0 >= y1 {
+			r.deleteBatches()
+			delete(view.regions, key)
+			continue
+		}
+		if r.dirty {
+			view.bake(r, x0, y0, x1, y1)
+			r.dirty = false
+		}
+	}
+}
+
+func (view *View) bake(r *region, x0, y0, x1, y1 int) {
+	r.deleteBatches()
+	byKey := map[bakedKey]*bakedBatch{}
+	add := func(b *BlockPos, block *Block, palette *Palette, z float32, tint [4]float32) {
+		key := bakedKey{block.texture, palette}
+		batch, ok := byKey[key]
+		if !ok {
+			batch = &bakedBatch{bakedKey: key}
+			byKey[key] = batch
+			r.batches = append(r.batches, batch)
+		}
+		// vertices are relative to the view's origin, bake them in world coordinates
+		wx := b.model.At(0, 3) + float32(view.loadedX)
+		wy := b.model.At(1, 3) + float32(view.loadedY)
+		for i := 0; i < len(block.verts); i += VERTEX_FLOATS {
+			batch.data = append(batch.data,
+				block.verts[i]+wx, block.verts[i+1]+wy, block.verts[i+2]+z,
+				block.verts[i+3], block.verts[i+4],
+				block.verts[i+5], block.verts[i+6], block.verts[i+7],
+				block.shape.Size[2], block.shape.AlphaMin, 0, shapeFlags(block.shape),
+				tint[0], tint[1], tint[2], tint[3],
+			)
+		}
+		batch.count += int32(len(block.verts) / VERTEX_FLOATS)
+	}
+
+	for x := x0; x < x1; x++ {
+		for y := y0; y < y1; y++ {
+			for z := 0; z < world.SECTION_Z_SIZE; z++ {
+				view.drawnAt(x, y, z, func(b *BlockPos, extraIndex int, block *Block, z float32, baked bool) {
+					if !baked {
+						return
+					}
+					// extras and edges are never tinted
+					if extraIndex == -1 && b.look != nil {
+						add(b, block, b.palette, z, b.look.Tint)
+					} else {
+						add(b, block, nil, z, WHITE)
+					}
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(r.batches, func(a, b int) bool {
+		return r.batches[a].texture.textureIndex < r.batches[b].texture.textureIndex
+	})
+	for _, batch := range r.batches {
+		gl.GenBuffers(1, &batch.vbo)
+		gl.BindBuffer(gl.ARRAY_BUFFER, batch.vbo)
+		gl.BufferData(gl.ARRAY_BUFFER, len(batch.data)*4, gl.Ptr(batch.data), gl.STATIC_DRAW)
+		batch.data = nil
+	}
+}
+
+func (view *View) drawBaked() {
+	// the union of the visible parts of each z level
+	minX, minY, maxX, maxY := SIZE, SIZE, 0, 0
+	for _, r := range view.drawRange {
+		if r[0] < r[2] && r[1] < r[3] {
+			minX, minY = util.MinInt(minX, r[0]), util.MinInt(minY, r[1])
+			maxX, maxY = util.MaxInt(maxX, r[2]), util.MaxInt(maxY, r[3])
+		}
+	}
+
+	gl.BindVertexArray(view.bakedVao)
+	// the attributes which aren't in the baked buffer: move from world to view coordinates, no scrolling
+	gl.VertexAttrib3f(view.instancePosAttrib, -float32(view.loadedX), -float32(view.loadedY), 0)
+	gl.VertexAttrib4f(view.instanceScrollAttrib, 0, 0, 0, 0)
+	for _, r := range view.regions {
+		x0, y0, x1, y1 := view.viewBounds(r)
+		if x1 <= minX || x0 >= maxX || y1 <= minY || y0 >= maxY {
+			continue
+		}
+		for _, batch := range r.batches {
+			if !state.init || state.texture != batch.texture.texture {
+				gl.BindTexture(gl.TEXTURE_2D, batch.texture.texture)
+				state.texture = batch.texture.texture
+			}
+			if batch.palette == nil {
+				gl.Uniform1i(view.paletteSizeUniform, 0)
+			} else {
+				gl.ActiveTexture(gl.TEXTURE1)
+				gl.BindTexture(gl.TEXTURE_2D, batch.palette.texture)
+				gl.ActiveTexture(gl.TEXTURE0)
+				gl.Uniform1i(view.paletteSizeUniform, batch.palette.size)
+			}
+			gl.BindBuffer(gl.ARRAY_BUFFER, batch.vbo)
+			gl.VertexAttribPointer(view.vertAttrib, 3, gl.FLOAT, false, BAKED_FLOATS*4, gl.PtrOffset(0))
+			gl.VertexAttribPointer(view.texCoordAttrib, 2, gl.FLOAT, false, BAKED_FLOATS*4, gl.PtrOffset(3*4))
+			gl.VertexAttribPointer(view.normalAttrib, 3, gl.FLOAT, false, BAKED_FLOATS*4, gl.PtrOffset(5*4))
+			gl.VertexAttribPointer(view.instanceParamsAttrib, 4, gl.FLOAT, false, BAKED_FLOATS*4, gl.PtrOffset(8*4))
+			gl.VertexAttribPointer(view.instanceTintAttrib, 4, gl.FLOAT, false, BAKED_FLOATS*4, gl.PtrOffset(12*4))
+			gl.DrawArrays(gl.TRIANGLES, 0, batch.count)
+			state.init = true
+		}
+	}
+	gl.BindVertexArray(view.vao)
+}
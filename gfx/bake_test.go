@@ -0,0 +1,148 @@
+package gfx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uzudil/isongn/shapes"
+)
+
+type drawnKey struct {
+	b          *BlockPos
+	extraIndex int
+}
+
+// bakedByRegion is what each region bakes, if it's baked now
+func bakedByRegion(view *View) map[regionKey]map[drawnKey]bool {
+	regions := map[regionKey]map[drawnKey]bool{}
+	view.traverse(func(x, y, z int) {
+		wx, wy, _ := view.toWorldPos(x, y, 0)
+		key := regionOf(wx, wy)
+		view.drawnAt(x, y, z, func(b *BlockPos, extraIndex int, block *Block, z float32, baked bool) {
+			if baked {
+				if regions[key] == nil {
+					regions[key] = map[drawnKey]bool{}
+				}
+				regions[key][drawnKey{b, extraIndex}] = true
+			}
+		})
+	})
+	return regions
+}
+
+// drawnShape tells if the shape at a world position is drawn, and if it's baked
+func drawnShape(view *View, worldX, worldY, worldZ int) (bool, bool) {
+	drawn, baked := false, false
+	x, y, z, _ := view.toViewPos(worldX, worldY, worldZ)
+	view.drawnAt(x, y, z, func(b *BlockPos, extraIndex int, block *Block, _ float32, isBaked bool) {
+		if b == view.at(x, y, z) && extraIndex == -1 {
+			drawn, baked = true, isBaked
+		}
+	})
+	return drawn, baked
+}
+
+func TestBakedCells(t *testing.T) {
+	defer func(old []*shapes.Shape) { shapes.Shapes = old }(shapes.Shapes)
+	shapes.Shapes = []*shapes.Shape{
+		{Index: 0, Name: "pillar", Size: [3]float32{1, 1, 2}},
+		{Index: 1, Name: "roof", Size: [3]float32{3, 3, 1}, Group: 1},
+		{Index: 2, Name: "man", Size: [3]float32{1, 1, 2}, Animations: map[int]*shapes.Animation{shapes.ANIMATION_MOVE: {}}},
+	}
+	blocks := []*Block{
+		{shape: shapes.Shapes[0], sizeX: 1, sizeY: 1, sizeZ: 2},
+		{shape: shapes.Shapes[1], sizeX: 3, sizeY: 3, sizeZ: 1},
+		{shape: shapes.Shapes[2], sizeX: 1, sizeY: 1, sizeZ: 2},
+	}
+	pillar := [3]int{5100, 5100, 0}
+	roof := [3]int{5099, 5099, 3}
+	man := [3]int{5110, 5100, 0}
+	occlude := func(pos [3]int) func(view *View) {
+		return func(view *View) {
+			occluders := map[*BlockPos]bool{}
+			if pos != [3]int{} {
+				occluders[view.GetBlockPos(pos[0], pos[1], pos[2])] = true
+			}
+			view.setOccluders(occluders)
+		}
+	}
+	tint := func(alpha float32) func(view *View) {
+		return func(view *View) {
+			if err := view.SetTint(pillar[0], pillar[1], pillar[2], 255, 128, 128, alpha); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	offset := func(dx float32) func(view *View) {
+		return func(view *View) {
+			view.SetOffset(pillar[0], pillar[1], pillar[2], dx, 0)
+		}
+	}
+	cutaway := func(on bool) func(view *View) {
+		return func(view *View) {
+			if on {
+				view.SetCutaway(pillar[0], pillar[1], pillar[2])
+			} else {
+				view.ClearCutaway()
+			}
+			view.updateRoofs()
+		}
+	}
+	tests := []struct {
+		name          string
+		before, after func(view *View)
+		at            [3]int
+		drawn, baked  bool
+	}{
+		{"static", nil, nil, pillar, true, true},
+		{"animated", nil, nil, man, true, false},
+		{"xray", nil, occlude(pillar), pillar, true, false},
+		{"xray cleared", occlude(pillar), occlude([3]int{}), pillar, true, true},
+		{"translucent tint", nil, tint(128), pillar, true, false},
+		{"opaque tint", nil, tint(255), pillar, true, true},
+		{"tint back to opaque", tint(128), tint(255), pillar, true, true},
+		{"offset", nil, offset(0.5), pillar, true, false},
+		{"offset back", offset(0.5), offset(0), pillar, true, true},
+		{"roof cut", nil, cutaway(true), roof, false, false},
+		{"under the roof cut", nil, cutaway(true), pillar, true, true},
+		{"roof restored", cutaway(true), cutaway(false), roof, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loader := newTestLoader(t)
+			for i, pos := range [][3]int{pillar, roof, man} {
+				loader.SetShape(pos[0], pos[1], pos[2], i)
+			}
+			loader.MoveTo(5100, 5100)
+			view := newTestView(t, loader, blocks)
+			view.Reload()
+			view.updateRoofs()
+			if test.before != nil {
+				test.before(view)
+			}
+
+			// bake everything, then change something
+			baked := bakedByRegion(view)
+			for _, r := range view.regions {
+				r.dirty = false
+			}
+			if test.after != nil {
+				test.after(view)
+			}
+			now := bakedByRegion(view)
+			for key := range now {
+				if _, ok := view.regions[key]; !ok {
+					t.Errorf("region %v bakes shapes but isn't tracked", key)
+				}
+			}
+			for key, r := range view.regions {
+				if !r.dirty && !reflect.DeepEqual(baked[key], now[key]) {
+					t.Errorf("region %v bakes other shapes but wasn't invalidated", key)
+				}
+			}
+			if drawn, baked := drawnShape(view, test.at[0], test.at[1], test.at[2]); drawn != test.drawn || baked != test.baked {
+				t.Errorf("got drawn %v baked %v, expected %v %v", drawn, baked, test.drawn, test.baked)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package gfx
+
+import (
+	"image"
+	"sort"
+
+	"github.com/go-gl/gl/all-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/uzudil/isongn/shapes"
+)
+
+// The view is drawn with one instanced draw call per block and palette, grouped by texture.
+// What only changes when the view changes (position, flags, tint) is in the static buffer, rebuilt
+// when the view is dirty. Scroll offsets and animation frames change every frame, they're in the
+// dynamic buffer, which is only updated for the instances which animate or scroll.
+//
+// Grouping by texture loses the back to front order, so the view is drawn in two passes: first
+// everything but the blended texels of translucent instances, in any order as the depth test sorts
+// it out, then only those blended texels, back to front and without writing depth.
+const (
+	STATIC_FLOATS  = 11 // position xyz, height, alphaMin, uniqueOffset, flags, tint rgba
+	DYNAMIC_FLOATS = 4  // scroll xy, texture offset xy
+)
+
+const (
+	FLAG_SWAY = 1 << iota
+	FLAG_BOB
+	FLAG_BREATHE
+	FLAG_EMISSIVE
+	FLAG_XRAY
+	FLAG_TRANSLUCENT
+)
+
+func shapeFlags(shape *shapes.Shape) float32 {
+	flags := 0
+	if shape.SwayEnabled {
+		flags |= FLAG_SWAY
+	}
+	if shape.BobEnabled {
+		flags |= FLAG_BOB
+	}
+	if shape.BreatheEnabled {
+		flags |= FLAG_BREATHE
+	}
+	if shape.Emissive {
+		flags |= FLAG_EMISSIVE
+	}
+	return float32(flags)
+}
+
+type instance struct {
+	blockPos   *BlockPos
+	extraIndex int
+	z          float32
+}
+
+type batchKey struct {
+	block   *Block
+	palette *Palette
+}
+
+type batch struct {
+	batchKey
+	start, count int32
+	instances    []instance
+}
+
+// a run of translucent instances next to each other in the buffers, drawn with one call
+type run struct {
+	batch        *batch
+	start, count int32
+}
+
+type Instances struct {
+	batches     []*batch
+	translucent []run
+	byKey       map[batchKey]*batch
+	instances   []instance
+	dynamic     []int
+	staticData  []float32
+	dynamicData []float32
+	staticVbo   uint32
+	dynamicVbo  uint32
+}
+
+func NewInstances() *Instances {
+	in := &Instances{}
+	gl.GenBuffers(1, &in.staticVbo)
+	gl.GenBuffers(1, &in.dynamicVbo)
+	return in
+}
+
+func (in *Instances) begin() {
+	in.batches = []*batch{}
+	in.translucent = in.translucent[:0]
+	in.byKey = map[batchKey]*batch{}
+	in.instances = in.instances[:0]
+	in.dynamic = in.dynamic[:0]
+}
+
+// add queues the block (or extra) of blockPos, drawn at model height z
+func (in *Instances) add(blockPos *BlockPos, extraIndex int, z float32) {
+	key := batchKey{blockPos.block, nil}
+	if extraIndex > -1 {
+		key.block = blockPos.extras[extraIndex]
+	} else if blockPos.look != nil {
+		key.palette = blockPos.palette
+	}
+	b, ok := in.byKey[key]
+	if !ok {
+		b = &batch{batchKey: key}
+		in.byKey[key] = b
+		in.batches = append(in.batches, b)
+	}
+	b.instances = append(b.instances, instance{blockPos, extraIndex, z})
+}
+
+// end lays out the batches by texture, sorts the translucent instances back to front as seen
+// looking against towardsCamera, and uploads the static data
+func (in *Instances) end(towardsCamera mgl32.Vec3) {
+	sort.SliceStable(in.batches, func(a, b int) bool {
+		return in.batches[a].block.texture.textureIndex < in.batches[b].block.texture.textureIndex
+	})
+	in.staticData = in.staticData[:0]
+	depths := map[int32]float32{}
+	for _, b := range in.batches {
+		b.start = int32(len(in.instances))
+		b.count = int32(len(b.instances))
+		for _, inst := range b.instances {
+			if inst.isDynamic(b.block) {
+				in.dynamic = append(in.dynamic, len(in.instances))
+				if inst.extraIndex == -1 {
+					inst.blockPos.dynamic = true
+				}
+			}
+			if inst.isTranslucent(b.block) {
+				i := int32(len(in.instances))
+				in.translucent = append(in.translucent, run{b, i, 1})
+				depths[i] = inst.depth(b.block, towardsCamera)
+			}
+			in.instances = append(in.instances, inst)
+			in.staticData = inst.appendStatic(in.staticData, b.block)
+		}
+		b.instances = nil
+	}
+	sort.SliceStable(in.translucent, func(a, b int) bool {
+		return depths[in.translucent[a].start] < depths[in.translucent[b].start]
+	})
+	// join the neighbours which are also next to each other in the buffers
+	runs := in.translucent[:0]
+	for _, r := range in.translucent {
+		if last := len(runs) - 1; last >= 0 && runs[last].batch == r.batch && runs[last].start+runs[last].count == r.start {
+			runs[last].count++
+		} else {
+			runs = append(runs, r)
+		}
+	}
+	in.translucent = runs
+	size := len(in.instances) * DYNAMIC_FLOATS
+	if cap(in.dynamicData) < size {
+		in.dynamicData = make([]float32, size)
+	}
+	in.dynamicData = in.dynamicData[:size]
+	for i := range in.dynamicData {
+		in.dynamicData[i] = 0
+	}
+	if len(in.instances) == 0 {
+		return
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, in.staticVbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(in.staticData)*4, gl.Ptr(in.staticData), gl.STATIC_DRAW)
+	gl.BindBuffer(gl.ARRAY_BUFFER, in.dynamicVbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(in.dynamicData)*4, gl.Ptr(in.dynamicData), gl.DYNAMIC_DRAW)
+}
+
+func (inst *instance) isDynamic(block *Block) bool {
+	if len(block.shape.Animations) > 0 {
+		return true
+	}
+	return inst.extraIndex == -1 && inst.blockPos.ScrollOffset != ZERO_OFFSET
+}
+
+// isTranslucent is true if some texels of the instance are blended with what's behind them
+func (inst *instance) isTranslucent(block *Block) bool {
+	return block.translucent || inst.extraIndex == -1 && inst.blockPos.look != nil && inst.blockPos.look.Tint[3] < 1
+}
+
+// depth is how far the middle of the instance is towards the camera
+func (inst *instance) depth(block *Block, towardsCamera mgl32.Vec3) float32 {
+	b := inst.blockPos
+	middle := mgl32.Vec3{b.model.At(0, 3) + block.sizeX/2, b.model.At(1, 3) + block.sizeY/2, inst.z + block.sizeZ/2}
+	return middle.Dot(towardsCamera)
+}
+
+// hasTranslucentTexels is true if the shape or its animation frames have texels which are neither
+// discarded by alphaMin nor opaque
+func hasTranslucentTexels(img image.Image, shape *shapes.Shape) bool {
+	coords := []*shapes.TextureCoords{shape.Tex}
+	for _, animation := range shape.Animations {
+		for _, steps := range animation.Tex {
+			coords = append(coords, steps...)
+		}
+	}
+	alphaMin := uint32(shape.AlphaMin * 0xffff)
+	for _, tc := range coords {
+		if tc == nil {
+			continue
+		}
+		x0, y0 := int(tc.PixelOffset[0]), int(tc.PixelOffset[1])
+		for y := y0; y < y0+int(tc.PixelDim[1]); y++ {
+			for x := x0; x < x0+int(tc.PixelDim[0]); x++ {
+				_, _, _, a := img.At(x, y).RGBA()
+				if a >= alphaMin && a < 0xffff {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (inst *instance) appendStatic(data []float32, block *Block) []float32 {
+	b := inst.blockPos
+	tint := WHITE
+	if inst.extraIndex == -1 && b.look != nil {
+		tint = b.look.Tint
+	}
+	flags := shapeFlags(block.shape)
+	if inst.extraIndex == -1 && b.xray {
+		flags += FLAG_XRAY
+	}
+	if inst.isTranslucent(block) {
+		flags += FLAG_TRANSLUCENT
+	}
+	return append(data,
+		b.model.At(0, 3), b.model.At(1, 3), inst.z,
+		block.shape.Size[2], block.shape.AlphaMin, float32(b.worldX+b.worldY+b.worldZ), flags,
+		tint[0], tint[1], tint[2], tint[3],
+	)
+}
+
+// drawOpaque updates the dynamic data and draws every batch, call it first
+func (in *Instances) drawOpaque(view *View) {
+	for _, i := range in.dynamic {
+		inst := &in.instances[i]
+		block := inst.blockPos.block
+		scroll := ZERO_OFFSET
+		if inst.extraIndex > -1 {
+			block = inst.blockPos.extras[inst.extraIndex]
+		} else {
+			scroll = inst.blockPos.ScrollOffset
+		}
+		textureOffset := inst.blockPos.textureOffset(block)
+		copy(in.dynamicData[i*DYNAMIC_FLOATS:], []float32{scroll[0], scroll[1], textureOffset[0], textureOffset[1]})
+	}
+	if len(in.dynamic) > 0 {
+		gl.BindBuffer(gl.ARRAY_BUFFER, in.dynamicVbo)
+		gl.BufferSubData(gl.ARRAY_BUFFER, 0, len(in.dynamicData)*4, gl.Ptr(in.dynamicData))
+	}
+
+	for _, b := range in.batches {
+		in.drawRun(view, b, b.start, b.count)
+	}
+}
+
+// drawTranslucent draws the translucent instances again, back to front
+func (in *Instances) drawTranslucent(view *View) {
+	for _, r := range in.translucent {
+		in.drawRun(view, r.batch, r.start, r.count)
+	}
+}
+
+func (in *Instances) drawRun(view *View, b *batch, start, count int32) {
+	if !state.init || state.texture != b.block.texture.texture {
+		gl.BindTexture(gl.TEXTURE_2D, b.block.texture.texture)
+		state.texture = b.block.texture.texture
+	}
+	if b.palette == nil {
+		gl.Uniform1i(view.paletteSizeUniform, 0)
+	} else {
+		gl.ActiveTexture(gl.TEXTURE1)
+		gl.BindTexture(gl.TEXTURE_2D, b.palette.texture)
+		gl.ActiveTexture(gl.TEXTURE0)
+		gl.Uniform1i(view.paletteSizeUniform, b.palette.size)
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, b.block.vbo)
+	gl.VertexAttribPointer(view.vertAttrib, 3, gl.FLOAT, false, VERTEX_FLOATS*4, gl.PtrOffset(0))
+	gl.VertexAttribPointer(view.texCoordAttrib, 2, gl.FLOAT, false, VERTEX_FLOATS*4, gl.PtrOffset(3*4))
+	gl.VertexAttribPointer(view.normalAttrib, 3, gl.FLOAT, false, VERTEX_FLOATS*4, gl.PtrOffset(5*4))
+
+	// no base instance in opengl 4.1: point the instance attributes at the first instance instead
+	offset := int(start) * STATIC_FLOATS * 4
+	gl.BindBuffer(gl.ARRAY_BUFFER, in.staticVbo)
+	gl.VertexAttribPointer(view.instancePosAttrib, 3, gl.FLOAT, false, STATIC_FLOATS*4, gl.PtrOffset(offset))
+	gl.VertexAttribPointer(view.instanceParamsAttrib, 4, gl.FLOAT, false, STATIC_FLOATS*4, gl.PtrOffset(offset+3*4))
+	gl.VertexAttribPointer(view.instanceTintAttrib, 4, gl.FLOAT, false, STATIC_FLOATS*4, gl.PtrOffset(offset+7*4))
+	gl.BindBuffer(gl.ARRAY_BUFFER, in.dynamicVbo)
+	gl.VertexAttribPointer(view.instanceScrollAttrib, 4, gl.FLOAT, false, DYNAMIC_FLOATS*4, gl.PtrOffset(int(start)*DYNAMIC_FLOATS*4))
+
+	gl.DrawArraysInstanced(gl.TRIANGLES, 0, int32(len(b.block.verts)/VERTEX_FLOATS), count)
+	state.init = true
+}
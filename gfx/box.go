@@ -1,10 +1,17 @@
 package gfx
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/uzudil/isongn/shapes"
+	"github.com/uzudil/isongn/util"
+)
 
 type BoundingBox struct {
 	X, Y, Z int
 	W, H, D int
+	// if set, only these cells collide
+	Mask *shapes.Mask
 }
 
 func (bb *BoundingBox) Set(x, y, z, w, h, d int) {
@@ -14,6 +21,10 @@ func (bb *BoundingBox) Set(x, y, z, w, h, d int) {
 	bb.D = d
 }
 
+func (bb *BoundingBox) SetMask(mask *shapes.Mask) {
+	bb.Mask = mask
+}
+
 func (bb *BoundingBox) SetPos(x, y, z int) {
 	bb.X = x
 	bb.Y = y
@@ -36,6 +47,33 @@ func (a *BoundingBox) intersect(b *BoundingBox) bool {
 		sideOverlap(a.Z, a.Z+a.D, b.Z, b.Z+b.D)
 }
 
+func (bb *BoundingBox) isSolid(x, y, z int) bool {
+	return bb.Mask == nil || bb.Mask.Solid(x-bb.X, y-bb.Y, z-bb.Z)
+}
+
+// collides is like intersect, but only counts cells which are solid in both boxes
+func (a *BoundingBox) collides(b *BoundingBox) bool {
+	if !a.intersect(b) {
+		return false
+	}
+	if a.Mask == nil && b.Mask == nil {
+		return true
+	}
+	x0, x1 := util.MaxInt(a.X, b.X), util.MinInt(a.X+a.W, b.X+b.W)
+	y0, y1 := util.MaxInt(a.Y, b.Y), util.MinInt(a.Y+a.H, b.Y+b.H)
+	z0, z1 := util.MaxInt(a.Z, b.Z), util.MinInt(a.Z+a.D, b.Z+b.D)
+	for x := x0; x < x1; x++ {
+		for y := y0; y < y1; y++ {
+			for z := z0; z < z1; z++ {
+				if a.isSolid(x, y, z) && b.isSolid(x, y, z) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (bp *BoundingBox) describe() string {
 	return fmt.Sprintf("%d,%d,%d-%d,%d,%d", bp.X, bp.Y, bp.Z, bp.X+bp.W, bp.Y+bp.H, bp.Z+bp.D)
 }
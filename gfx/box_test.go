@@ -0,0 +1,45 @@
+package gfx
+
+import (
+	"testing"
+
+	"github.com/uzudil/isongn/shapes"
+)
+
+func TestCollides(t *testing.T) {
+	// solid everywhere but x=1,y=0
+	l := shapes.NewMask([3]float32{2, 2, 1}, [][]string{{"#.", "##"}})
+	// solid on top, with an empty cell under the middle
+	arch := shapes.NewMask([3]float32{3, 1, 2}, [][]string{{"#.#"}, {"###"}})
+	// a single solid cell in the corner
+	corner := shapes.NewMask([3]float32{2, 2, 1}, [][]string{{"#.", ".."}})
+	tests := []struct {
+		name     string
+		a, b     BoundingBox
+		expected bool
+	}{
+		{"plain boxes", BoundingBox{0, 0, 0, 2, 2, 1, nil}, BoundingBox{1, 1, 0, 1, 1, 1, nil}, true},
+		{"apart", BoundingBox{0, 0, 0, 2, 2, 1, nil}, BoundingBox{2, 0, 0, 1, 1, 1, nil}, false},
+		{"l solid corner", BoundingBox{0, 0, 0, 2, 2, 1, l}, BoundingBox{0, 0, 0, 1, 1, 1, nil}, true},
+		{"l solid leg", BoundingBox{0, 0, 0, 2, 2, 1, l}, BoundingBox{1, 1, 0, 1, 1, 1, nil}, true},
+		{"l empty corner", BoundingBox{0, 0, 0, 2, 2, 1, l}, BoundingBox{1, 0, 0, 1, 1, 1, nil}, false},
+		{"l above", BoundingBox{0, 0, 0, 2, 2, 1, l}, BoundingBox{0, 0, 1, 1, 1, 1, nil}, false},
+		{"under the arch", BoundingBox{0, 0, 0, 3, 1, 2, arch}, BoundingBox{1, 0, 0, 1, 1, 1, nil}, false},
+		{"in the arch", BoundingBox{0, 0, 0, 3, 1, 2, arch}, BoundingBox{1, 0, 1, 1, 1, 1, nil}, true},
+		{"too tall for the arch", BoundingBox{0, 0, 0, 3, 1, 2, arch}, BoundingBox{1, 0, 0, 1, 1, 2, nil}, true},
+		{"arch pillar", BoundingBox{0, 0, 0, 3, 1, 2, arch}, BoundingBox{2, 0, 0, 1, 1, 1, nil}, true},
+		{"masks on empty cells", BoundingBox{0, 0, 0, 2, 2, 1, l}, BoundingBox{1, 0, 0, 2, 2, 1, corner}, false},
+		{"masks on solid cells", BoundingBox{0, 0, 0, 2, 2, 1, l}, BoundingBox{0, 1, 0, 2, 2, 1, corner}, true},
+		{"masks under the arch", BoundingBox{0, 0, 0, 3, 1, 2, arch}, BoundingBox{1, 0, 0, 2, 2, 1, corner}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.a.collides(&test.b); got != test.expected {
+				t.Errorf("a collides with b: got %v, expected %v", got, test.expected)
+			}
+			if got := test.b.collides(&test.a); got != test.expected {
+				t.Errorf("b collides with a: got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package gfx
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Camera moves the view for the scripts: it follows a position with easing, pans over time,
+// shakes and zooms. The view's center is the loader's position plus the view's scroll offset,
+// the camera keeps its own fractional position and splits it between the two every frame.
+// Until one of its methods is called it leaves the view alone, so setViewScroll and moveViewTo
+// keep working; Release hands the view back to them.
+type Camera struct {
+	app    *App
+	active bool
+	pos    [3]float64
+	// following: ease towards target, about 1/speed seconds to get most of the way
+	following bool
+	target    [3]float64
+	speed     float64
+	// panning: from pan[0] to pan[1] over panTime seconds
+	panning        bool
+	pan            [2][3]float64
+	panTime, panAt float64
+	// shaking: up to shakeStrength world units, fading out over shakeTime seconds
+	shakeStrength      float64
+	shakeTime, shakeAt float64
+	// zooming: from zoom[0] to zoom[1] over zoomTime seconds
+	zooming          bool
+	zoom             [2]float64
+	zoomTime, zoomAt float64
+}
+
+func NewCamera(app *App) *Camera {
+	return &Camera{app: app}
+}
+
+// activate starts from where the view is
+func (c *Camera) activate() {
+	if !c.active {
+		view := c.app.View
+		c.pos = [3]float64{
+			float64(c.app.Loader.X) + float64(view.ScrollOffset[0]),
+			float64(c.app.Loader.Y) + float64(view.ScrollOffset[1]),
+			float64(view.ScrollOffset[2]),
+		}
+		c.active = true
+	}
+}
+
+// Follow eases the camera towards the world position, call it again when the position moves.
+// The speed must be positive.
+func (c *Camera) Follow(x, y, z, speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("speed must be a positive number, not %v", speed)
+	}
+	c.activate()
+	c.following = true
+	c.panning = false
+	c.target = [3]float64{x, y, z}
+	c.speed = speed
+	return nil
+}
+
+// PanTo moves the camera to the world position over seconds, then it stays there.
+func (c *Camera) PanTo(x, y, z, seconds float64) {
+	c.activate()
+	c.following = false
+	c.panning = true
+	c.pan = [2][3]float64{c.pos, {x, y, z}}
+	c.panTime = math.Max(seconds, 0.001)
+	c.panAt = 0
+}
+
+// JumpTo puts the camera at the world position at once.
+func (c *Camera) JumpTo(x, y, z float64) {
+	c.activate()
+	c.following = false
+	c.panning = false
+	c.pos = [3]float64{x, y, z}
+}
+
+func (c *Camera) Shake(strength, seconds float64) {
+	c.activate()
+	c.shakeStrength = strength
+	c.shakeTime = math.Max(seconds, 0.001)
+	c.shakeAt = 0
+}
+
+// ZoomTo changes the zoom over seconds, at once if seconds is 0. The zoom is kept within the view's limits.
+func (c *Camera) ZoomTo(zoom, seconds float64) {
+	zoom = c.app.View.clampZoom(zoom)
+	if seconds <= 0 {
+		c.zooming = false
+		c.app.View.SetZoom(zoom)
+		return
+	}
+	c.zooming = true
+	c.zoom = [2]float64{c.app.View.zoom, zoom}
+	c.zoomTime = seconds
+	c.zoomAt = 0
+}
+
+// Release stops moving the view, it stays where it is.
+func (c *Camera) Release() {
+	c.active = false
+	c.following = false
+	c.panning = false
+	c.shakeStrength = 0
+}
+
+// smoothstep eases in and out
+func smoothstep(t float64) float64 {
+	t = math.Min(math.Max(t, 0), 1)
+	return t * t * (3 - 2*t)
+}
+
+func (c *Camera) Update(delta float64) {
+	if c.zooming {
+		c.zoomAt += delta
+		t := smoothstep(c.zoomAt / c.zoomTime)
+		c.app.View.SetZoom(c.zoom[0] + (c.zoom[1]-c.zoom[0])*t)
+		if c.zoomAt >= c.zoomTime {
+			c.zooming = false
+		}
+	}
+	if !c.active {
+		return
+	}
+	if c.following {
+		// frame rate independent exponential easing
+		f := 1 - math.Exp(-c.speed*delta)
+		for i := range c.pos {
+			c.pos[i] += (c.target[i] - c.pos[i]) * f
+		}
+	}
+	if c.panning {
+		c.panAt += delta
+		t := smoothstep(c.panAt / c.panTime)
+		for i := range c.pos {
+			c.pos[i] = c.pan[0][i] + (c.pan[1][i]-c.pan[0][i])*t
+		}
+		if c.panAt >= c.panTime {
+			c.panning = false
+		}
+	}
+	shake := [2]float64{}
+	if c.shakeStrength > 0 {
+		c.shakeAt += delta
+		if c.shakeAt >= c.shakeTime {
+			c.shakeStrength = 0
+		} else {
+			s := c.shakeStrength * (1 - c.shakeAt/c.shakeTime)
+			shake = [2]float64{(rand.Float64()*2 - 1) * s, (rand.Float64()*2 - 1) * s}
+		}
+	}
+
+	// the whole part moves the loader, the rest scrolls the view
+	x, y := int(math.Round(c.pos[0])), int(math.Round(c.pos[1]))
+	if c.app.Loader.MoveTo(x, y) {
+		c.app.View.Load()
+	}
+	c.app.View.Scroll(
+		float32(c.pos[0]-float64(c.app.Loader.X)+shake[0]),
+		float32(c.pos[1]-float64(c.app.Loader.Y)+shake[1]),
+		float32(c.pos[2]),
+	)
+}
@@ -0,0 +1,172 @@
+package gfx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/uzudil/isongn/shapes"
+	"github.com/uzudil/isongn/util"
+)
+
+type AppConfig struct {
+	GameDir    string
+	Title      string
+	Name       string
+	Version    float64
+	ViewSize   int
+	ViewSizeZ  int
+	SectorSize int
+	runtime    map[string]*runtimeConfig
+	zoom       float64
+	camera     [3]float32
+	shear      [3]float32
+	Include    shapes.IncludeConfig
+	shapes     []shapes.SheetConfig
+	creatures  []shapes.CreatureConfig
+	particles  map[string]*EmitterConfig
+}
+
+// configFile mirrors the layout of config.json
+type configFile struct {
+	Title     string                    `json:"title"`
+	Name      string                    `json:"name"`
+	Version   float64                   `json:"version"`
+	View      viewConfig                `json:"view"`
+	Runtime   map[string]*runtimeConfig `json:"runtime"`
+	Shapes    []shapes.SheetConfig      `json:"shapes"`
+	Creatures []shapes.CreatureConfig   `json:"creatures"`
+	Include   shapes.IncludeConfig      `json:"include"`
+	Particles map[string]*EmitterConfig `json:"particles"`
+}
+
+type viewConfig struct {
+	Size   int       `json:"size"`
+	SizeZ  int       `json:"sizeZ"`
+	Sector int       `json:"sector"`
+	Zoom   float64   `json:"zoom"`
+	Camera []float32 `json:"camera"`
+	Shear  []float32 `json:"shear"`
+}
+
+// runtimeConfig is the per-mode (runner or editor) section. The engine reads the typed fields,
+// values holds the whole section for the game itself.
+type runtimeConfig struct {
+	Resolution []int  `json:"resolution"`
+	Font       string `json:"font"`
+	FontSize   int    `json:"fontSize"`
+	values     map[string]interface{}
+}
+
+func parseConfig(gameDir, mode string) (*AppConfig, error) {
+	configPath := filepath.Join(gameDir, "config.json")
+	bytes, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	data := &configFile{}
+	if err = util.DecodeJSON(configPath, bytes, data); err != nil {
+		return nil, err
+	}
+	// the runtime sections are also passed to the game as-is
+	values := struct {
+		Runtime map[string]map[string]interface{} `json:"runtime"`
+	}{}
+	if err = util.DecodeJSON(configPath, bytes, &values); err != nil {
+		return nil, err
+	}
+	for name, r := range data.Runtime {
+		r.values = values.Runtime[name]
+	}
+
+	errors := shapes.ConfigErrors{}
+	fail := func(path, format string, args ...interface{}) {
+		errors.Add(configPath, path, "", format, args...)
+	}
+	if data.Title == "" {
+		fail("title", "missing required key")
+	}
+	if data.Name == "" {
+		fail("name", "missing required key")
+	}
+	if data.View.Size <= 0 {
+		fail("view.size", "must be a positive number")
+	}
+	if data.View.SizeZ <= 0 {
+		fail("view.sizeZ", "must be a positive number")
+	}
+	if data.View.Sector <= 0 {
+		fail("view.sector", "must be a positive number")
+	}
+	if data.View.Zoom <= 0 {
+		fail("view.zoom", "must be a positive number")
+	}
+	if len(data.View.Camera) != 3 {
+		fail("view.camera", "expected 3 values, got %d", len(data.View.Camera))
+	}
+	if len(data.View.Shear) != 3 {
+		fail("view.shear", "expected 3 values, got %d", len(data.View.Shear))
+	}
+
+	if mode == "" {
+		// only the shapes are needed
+	} else if r, ok := data.Runtime[mode]; ok == false || r == nil {
+		fail("runtime."+mode, "missing required key")
+	} else {
+		if len(r.Resolution) != 2 {
+			fail("runtime."+mode+".resolution", "expected 2 values, got %d", len(r.Resolution))
+		}
+		if r.Font == "" {
+			fail("runtime."+mode+".font", "missing required key")
+		}
+		if r.FontSize <= 0 {
+			fail("runtime."+mode+".fontSize", "must be a positive number")
+		}
+	}
+	for name, c := range data.Particles {
+		validateEmitter(name, c, fail)
+	}
+	// inline definitions come first, then the included files
+	shapes.SetSource(configPath, data.Shapes, data.Creatures)
+	sheets, creatures, err := shapes.ReadIncludes(gameDir, data.Include)
+	if err != nil {
+		return nil, err
+	}
+	data.Shapes = append(data.Shapes, sheets...)
+	data.Creatures = append(data.Creatures, creatures...)
+
+	errors = append(errors, shapes.Validate(data.Shapes, data.Creatures)...)
+	if len(errors) > 0 {
+		return nil, errors
+	}
+
+	config := &AppConfig{
+		GameDir:    gameDir,
+		Title:      data.Title,
+		Name:       strings.ToLower(data.Name),
+		Version:    data.Version,
+		ViewSize:   data.View.Size,
+		ViewSizeZ:  data.View.SizeZ,
+		SectorSize: data.View.Sector,
+		runtime:    data.Runtime,
+		zoom:       data.View.Zoom,
+		camera:     [3]float32{data.View.Camera[0], data.View.Camera[1], data.View.Camera[2]},
+		shear:      [3]float32{data.View.Shear[0], data.View.Shear[1], data.View.Shear[2]},
+		Include:    data.Include,
+		shapes:     data.Shapes,
+		creatures:  data.Creatures,
+		particles:  data.Particles,
+	}
+	fmt.Printf("Starting game: %s (v%f)\n", config.Title, config.Version)
+	return config, nil
+}
+
+// LoadShapeConfig reads and checks the shape and creature definitions of a game, without starting it.
+func LoadShapeConfig(gameDir string) ([]shapes.SheetConfig, []shapes.CreatureConfig, error) {
+	config, err := parseConfig(gameDir, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return config.shapes, config.creatures, nil
+}
@@ -0,0 +1,83 @@
+package gfx
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/uzudil/isongn/world"
+)
+
+// DrawRange is the part of the grid which is on screen, per z level: minX, minY, maxX, maxY in view
+// coordinates, max is exclusive.
+type DrawRange [world.SECTION_Z_SIZE][4]int
+
+// computeDrawRange casts a ray through each corner of the screen and sees where it crosses each z level.
+func (view *View) computeDrawRange() DrawRange {
+	inverse := view.projection.Mul4(view.camera).Inv()
+	rays := [4][2]mgl32.Vec3{}
+	for i, corner := range [4][2]float32{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+		rays[i][0] = mgl32.TransformCoordinate(mgl32.Vec3{corner[0], corner[1], -1}, inverse)
+		rays[i][1] = mgl32.TransformCoordinate(mgl32.Vec3{corner[0], corner[1], 1}, inverse)
+	}
+
+	// shapes are drawn from their origin towards +x, +y and +z: include those starting outside the screen
+	margin := int(math.Max(float64(view.maxShapeSize[0]), float64(view.maxShapeSize[1]))) + 1
+	r := DrawRange{}
+	for z := range r {
+		minX, minY := float32(math.MaxFloat32), float32(math.MaxFloat32)
+		maxX, maxY := -minX, -minY
+		for _, level := range []float32{float32(z), float32(z) + view.maxShapeSize[2]} {
+			for _, ray := range rays {
+				near, far := ray[0], ray[1]
+				if far.Z() == near.Z() {
+					continue
+				}
+				t := (level - view.ScrollOffset[2] - near.Z()) / (far.Z() - near.Z())
+				p := near.Add(far.Sub(near).Mul(t))
+				x := p.X() + view.ScrollOffset[0]
+				y := p.Y() + view.ScrollOffset[1]
+				minX = float32(math.Min(float64(minX), float64(x)))
+				minY = float32(math.Min(float64(minY), float64(y)))
+				maxX = float32(math.Max(float64(maxX), float64(x)))
+				maxY = float32(math.Max(float64(maxY), float64(y)))
+			}
+		}
+		r[z] = [4]int{
+			clampView(int(math.Floor(float64(minX))) + SIZE/2 - margin),
+			clampView(int(math.Floor(float64(minY))) + SIZE/2 - margin),
+			clampView(int(math.Ceil(float64(maxX))) + SIZE/2 + 2),
+			clampView(int(math.Ceil(float64(maxY))) + SIZE/2 + 2),
+		}
+	}
+	return r
+}
+
+// maxGridZoom is the largest zoom at which the ground on screen is still inside the grid, with room to scroll.
+// The camera looks at the origin, so the ground's footprint grows in proportion to the zoom.
+func (view *View) maxGridZoom() float64 {
+	inverse := getProjection(1, view.shear).Mul4(view.camera).Inv()
+	extent := 0.0
+	for _, corner := range [4][2]float32{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+		near := mgl32.TransformCoordinate(mgl32.Vec3{corner[0], corner[1], -1}, inverse)
+		far := mgl32.TransformCoordinate(mgl32.Vec3{corner[0], corner[1], 1}, inverse)
+		if far.Z() == near.Z() {
+			continue
+		}
+		p := near.Add(far.Sub(near).Mul(-near.Z() / (far.Z() - near.Z())))
+		extent = math.Max(extent, math.Max(math.Abs(float64(p.X())), math.Abs(float64(p.Y()))))
+	}
+	if extent == 0 {
+		return math.MaxFloat64
+	}
+	return float64(SIZE/2-2) / extent
+}
+
+func clampView(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > SIZE {
+		return SIZE
+	}
+	return v
+}
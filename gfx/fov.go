@@ -0,0 +1,197 @@
+package gfx
+
+import (
+	"github.com/go-gl/gl/all-core/gl"
+	"github.com/uzudil/isongn/util"
+	"github.com/uzudil/isongn/world"
+)
+
+// How bright the explored but not currently visible cells are. Unexplored cells aren't drawn.
+const (
+	FOG_UNEXPLORED = 0
+	FOG_EXPLORED   = 90
+	FOG_VISIBLE    = 255
+)
+
+// Fov is what the viewer sees: shadowcasting over the columns of the view grid, where a column is
+// opaque if a shape with the blocksSight prop covers it at the viewer's height.
+type Fov struct {
+	enabled bool
+	dirty   bool
+	x, y, z int
+	radius  int
+	visible map[[2]int]bool
+	opaque  map[[2]int]bool
+	texture uint32
+	fog     [SIZE * SIZE]uint8
+}
+
+// the octants as multipliers of the row and column
+var octants = [8][4]int{
+	{1, 0, 0, 1}, {0, 1, 1, 0}, {0, -1, 1, 0}, {-1, 0, 0, 1},
+	{-1, 0, 0, -1}, {0, -1, -1, 0}, {0, 1, -1, 0}, {1, 0, 0, -1},
+}
+
+func (view *View) initFov() {
+	gl.GenTextures(1, &view.fov.texture)
+	gl.ActiveTexture(gl.TEXTURE2)
+	gl.BindTexture(gl.TEXTURE_2D, view.fov.texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R8, SIZE, SIZE, 0, gl.RED, gl.UNSIGNED_BYTE, gl.Ptr(&view.fov.fog[0]))
+	gl.ActiveTexture(gl.TEXTURE0)
+}
+
+// SetViewer turns on the field of view, seen from this world position out to radius.
+func (view *View) SetViewer(worldX, worldY, worldZ, radius int) {
+	fov := &view.fov
+	if !fov.enabled || fov.x != worldX || fov.y != worldY || fov.z != worldZ || fov.radius != radius {
+		fov.enabled = true
+		fov.x, fov.y, fov.z, fov.radius = worldX, worldY, worldZ, radius
+		fov.dirty = true
+	}
+}
+
+// ClearViewer turns off the field of view, everything is drawn.
+func (view *View) ClearViewer() {
+	view.fov.enabled = false
+	view.fov.visible = nil
+}
+
+// IsVisible is true if the viewer can see the column of this position, or if there is no viewer.
+func (view *View) IsVisible(worldX, worldY, worldZ int) bool {
+	if !view.fov.enabled {
+		return true
+	}
+	view.updateFov()
+	return view.fov.visible[[2]int{worldX, worldY}]
+}
+
+// fovChanged recomputes the field of view if a change at this position can affect it
+func (view *View) fovChanged(blockPos *BlockPos) {
+	if blockPos.block != nil && blockPos.block.shape.BlocksSight {
+		view.fov.dirty = true
+	}
+}
+
+func (view *View) updateFov() {
+	fov := &view.fov
+	if !fov.dirty {
+		return
+	}
+	fov.dirty = false
+	fov.visible = map[[2]int]bool{}
+	view.findOpaque()
+	fov.see(fov.x, fov.y)
+	for _, o := range octants {
+		fov.castLight(1, 1, 0, o[0], o[1], o[2], o[3])
+	}
+	for pos := range fov.visible {
+		if pos[0] >= 0 && pos[1] >= 0 {
+			view.Loader.SetExplored(pos[0], pos[1])
+		}
+	}
+	view.uploadFog()
+}
+
+// findOpaque marks the columns around the viewer which block sight
+func (view *View) findOpaque() {
+	fov := &view.fov
+	fov.opaque = map[[2]int]bool{}
+	vx, vy, _, _ := view.toViewPos(fov.x, fov.y, fov.z)
+	if fov.z < 0 || fov.z >= world.SECTION_Z_SIZE {
+		return
+	}
+	// shapes start at most their size before the cells they cover
+	x0, y0 := clampView(vx-fov.radius-int(view.maxShapeSize[0])), clampView(vy-fov.radius-int(view.maxShapeSize[1]))
+	x1, y1 := clampView(vx+fov.radius+1), clampView(vy+fov.radius+1)
+	for x := x0; x < x1; x++ {
+		for y := y0; y < y1; y++ {
+			for z := util.MaxInt(0, fov.z-int(view.maxShapeSize[2])); z <= fov.z; z++ {
+				blockPos := view.at(x, y, z)
+				if blockPos.block == nil || !blockPos.block.shape.BlocksSight {
+					continue
+				}
+				box := &blockPos.box
+				if fov.z < box.Z || fov.z >= box.Z+box.D {
+					continue
+				}
+				for bx := box.X; bx < box.X+box.W; bx++ {
+					for by := box.Y; by < box.Y+box.H; by++ {
+						if box.isSolid(bx, by, fov.z) {
+							wx, wy, _ := view.toWorldPos(bx, by, fov.z)
+							fov.opaque[[2]int{wx, wy}] = true
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (fov *Fov) see(x, y int) {
+	fov.visible[[2]int{x, y}] = true
+}
+
+// castLight scans one octant row by row, narrowing the slopes seen past opaque columns (recursive shadowcasting)
+func (fov *Fov) castLight(row int, start, end float64, xx, xy, yx, yy int) {
+	if start < end {
+		return
+	}
+	newStart := 0.0
+	for j := row; j <= fov.radius; j++ {
+		blocked := false
+		for dx, dy := -j-1, -j; dx <= 0; {
+			dx++
+			x := fov.x + dx*xx + dy*xy
+			y := fov.y + dx*yx + dy*yy
+			leftSlope := (float64(dx) - 0.5) / (float64(dy) + 0.5)
+			rightSlope := (float64(dx) + 0.5) / (float64(dy) - 0.5)
+			if start < rightSlope {
+				continue
+			} else if end > leftSlope {
+				break
+			}
+			if dx*dx+dy*dy <= fov.radius*fov.radius {
+				fov.see(x, y)
+			}
+			opaque := fov.opaque[[2]int{x, y}]
+			if blocked {
+				if opaque {
+					newStart = rightSlope
+					continue
+				}
+				blocked = false
+				start = newStart
+			} else if opaque && j < fov.radius {
+				blocked = true
+				fov.castLight(j+1, start, leftSlope, xx, xy, yx, yy)
+				newStart = rightSlope
+			}
+		}
+		if blocked {
+			break
+		}
+	}
+}
+
+// uploadFog sends how visible each column of the view is to the shader
+func (view *View) uploadFog() {
+	fov := &view.fov
+	for x := 0; x < SIZE; x++ {
+		for y := 0; y < SIZE; y++ {
+			wx, wy, _ := view.toWorldPos(x, y, 0)
+			fog := uint8(FOG_UNEXPLORED)
+			if fov.visible[[2]int{wx, wy}] {
+				fog = FOG_VISIBLE
+			} else if wx >= 0 && wy >= 0 && view.Loader.IsExplored(wx, wy) {
+				fog = FOG_EXPLORED
+			}
+			fov.fog[y*SIZE+x] = fog
+		}
+	}
+	gl.ActiveTexture(gl.TEXTURE2)
+	gl.BindTexture(gl.TEXTURE_2D, fov.texture)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, SIZE, SIZE, gl.RED, gl.UNSIGNED_BYTE, gl.Ptr(&fov.fog[0]))
+	gl.ActiveTexture(gl.TEXTURE0)
+}
@@ -0,0 +1,52 @@
+package gfx
+
+import "testing"
+
+func TestCastLight(t *testing.T) {
+	tests := []struct {
+		name    string
+		opaque  [][2]int
+		visible [][2]int
+		hidden  [][2]int
+	}{
+		{
+			name:    "open",
+			visible: [][2]int{{10, 10}, {15, 10}, {10, 5}, {13, 13}, {7, 14}},
+			hidden:  [][2]int{{15, 15}, {16, 10}},
+		},
+		{
+			name:    "pillar",
+			opaque:  [][2]int{{12, 10}},
+			visible: [][2]int{{12, 10}, {10, 14}, {12, 12}},
+			hidden:  [][2]int{{13, 10}, {14, 10}},
+		},
+		{
+			name:    "wall",
+			opaque:  [][2]int{{12, 7}, {12, 8}, {12, 9}, {12, 10}, {12, 11}, {12, 12}, {12, 13}},
+			visible: [][2]int{{11, 10}, {12, 10}, {12, 13}, {8, 10}},
+			hidden:  [][2]int{{13, 10}, {14, 12}, {15, 10}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fov := &Fov{x: 10, y: 10, radius: 5, visible: map[[2]int]bool{}, opaque: map[[2]int]bool{}}
+			for _, pos := range test.opaque {
+				fov.opaque[pos] = true
+			}
+			fov.see(fov.x, fov.y)
+			for _, o := range octants {
+				fov.castLight(1, 1, 0, o[0], o[1], o[2], o[3])
+			}
+			for _, pos := range test.visible {
+				if !fov.visible[pos] {
+					t.Errorf("%v should be visible", pos)
+				}
+			}
+			for _, pos := range test.hidden {
+				if fov.visible[pos] {
+					t.Errorf("%v should be hidden", pos)
+				}
+			}
+		})
+	}
+}
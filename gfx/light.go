@@ -0,0 +1,110 @@
+package gfx
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-gl/gl/all-core/gl"
+)
+
+// the nearest lights to the middle of the view are used
+const MAX_LIGHTS = 32
+
+// PointLight is a light added by a script, at a world position.
+type PointLight struct {
+	Pos     [3]float32
+	Color   [3]float32
+	Radius  float32
+	Flicker float32
+}
+
+type activeLight struct {
+	pos     [3]float32
+	color   [3]float32
+	radius  float32
+	flicker float32
+	seed    float32
+}
+
+func (view *View) AddLight(light PointLight) int {
+	view.lightID++
+	view.lights[view.lightID] = &light
+	return view.lightID
+}
+
+func (view *View) MoveLight(id int, x, y, z float32) bool {
+	if light, ok := view.lights[id]; ok {
+		light.Pos = [3]float32{x, y, z}
+		return true
+	}
+	return false
+}
+
+func (view *View) RemoveLight(id int) bool {
+	if _, ok := view.lights[id]; ok {
+		delete(view.lights, id)
+		return true
+	}
+	return false
+}
+
+// trackLight remembers the positions holding a shape with a light
+func (view *View) trackLight(blockPos *BlockPos) {
+	if blockPos.block != nil && blockPos.block.shape.Light != nil {
+		view.shapeLights[blockPos] = true
+	} else {
+		delete(view.shapeLights, blockPos)
+	}
+}
+
+// uploadLights sends the lights, in view coordinates, to the shader
+func (view *View) uploadLights() {
+	lights := []activeLight{}
+	for blockPos := range view.shapeLights {
+		light := blockPos.block.shape.Light
+		lights = append(lights, activeLight{
+			pos: [3]float32{
+				blockPos.model.At(0, 3) + blockPos.ScrollOffset[0] + light.Offset[0],
+				blockPos.model.At(1, 3) + blockPos.ScrollOffset[1] + light.Offset[1],
+				blockPos.model.At(2, 3) + light.Offset[2],
+			},
+			color:   light.Color,
+			radius:  light.Radius,
+			flicker: light.Flicker,
+			seed:    float32(blockPos.worldX*7 + blockPos.worldY*13 + blockPos.worldZ),
+		})
+	}
+	for id, light := range view.lights {
+		lights = append(lights, activeLight{
+			pos:     [3]float32{light.Pos[0] - float32(view.loadedX), light.Pos[1] - float32(view.loadedY), light.Pos[2]},
+			color:   light.Color,
+			radius:  light.Radius,
+			flicker: light.Flicker,
+			seed:    float32(id),
+		})
+	}
+	if len(lights) > MAX_LIGHTS {
+		center := [2]float32{view.ScrollOffset[0], view.ScrollOffset[1]}
+		dist := func(l *activeLight) float32 {
+			dx, dy := l.pos[0]-center[0], l.pos[1]-center[1]
+			return dx*dx + dy*dy - l.radius*l.radius
+		}
+		sort.Slice(lights, func(a, b int) bool { return dist(&lights[a]) < dist(&lights[b]) })
+		lights = lights[:MAX_LIGHTS]
+	}
+
+	pos := make([]float32, 0, len(lights)*4)
+	color := make([]float32, 0, len(lights)*3)
+	for _, l := range lights {
+		// flicker dims the light by up to its amount, a mix of two waves so it doesn't look regular
+		t := state.time + float64(l.seed)
+		dim := float32(0.5+0.25*math.Sin(t*7.3)+0.25*math.Sin(t*17.1+1.3)) * l.flicker
+		pos = append(pos, l.pos[0], l.pos[1], l.pos[2], l.radius)
+		color = append(color, l.color[0]*(1-dim), l.color[1]*(1-dim), l.color[2]*(1-dim))
+	}
+	gl.Uniform1i(view.lightCountUniform, int32(len(lights)))
+	if len(lights) > 0 {
+		gl.Uniform4fv(view.lightPosUniform, int32(len(lights)), &pos[0])
+		gl.Uniform3fv(view.lightColorUniform, int32(len(lights)), &color[0])
+	}
+}
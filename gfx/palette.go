@@ -0,0 +1,116 @@
+package gfx
+
+import (
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/uzudil/isongn/world"
+)
+
+// a palette image has one column per colour: the top row is the colour to replace, the bottom row its replacement
+const MAX_PALETTE_SIZE = 256
+
+var WHITE = [4]float32{1, 1, 1, 1}
+
+type Palette struct {
+	texture uint32
+	size    int32
+}
+
+func loadPalette(path string) (*Palette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if h != 2 || w < 1 || w > MAX_PALETTE_SIZE {
+		return nil, fmt.Errorf("%s: palette should be 2 pixels high and 1-%d wide, not %dx%d", path, MAX_PALETTE_SIZE, w, h)
+	}
+	texture, err := loadTexture(img)
+	if err != nil {
+		return nil, err
+	}
+	// loadTexture leaves the palette bound, restore the shape texture
+	state.init = false
+	return &Palette{texture, int32(w)}, nil
+}
+
+func (view *View) getPalette(name string) (*Palette, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if palette, ok := view.palettes[name]; ok {
+		return palette, nil
+	}
+	palette, err := loadPalette(filepath.Join(view.gameDir, "palettes", name+".png"))
+	if err != nil {
+		return nil, err
+	}
+	view.palettes[name] = palette
+	return palette, nil
+}
+
+func (view *View) setLook(blockPos *BlockPos, look *world.Look) {
+	blockPos.look = look
+	blockPos.palette = nil
+	view.invalidate(blockPos.worldX, blockPos.worldY)
+	if look != nil {
+		palette, err := view.getPalette(look.Palette)
+		if err != nil {
+			fmt.Printf("Can't load palette: %v\n", err)
+		}
+		blockPos.palette = palette
+	}
+}
+
+// SetTint multiplies the colours of the shape at this position, rgba are 0-255. Extras aren't tinted.
+func (view *View) SetTint(worldX, worldY, worldZ int, r, g, b, a float32) error {
+	if _, hasShape := view.Loader.GetShape(worldX, worldY, worldZ); !hasShape {
+		return fmt.Errorf("no shape at %d,%d,%d", worldX, worldY, worldZ)
+	}
+	look := view.lookAt(worldX, worldY, worldZ)
+	look.Tint = [4]float32{r / 255, g / 255, b / 255, a / 255}
+	view.updateLook(worldX, worldY, worldZ, look)
+	return nil
+}
+
+// SetPalette remaps the colours of the shape at this position with palettes/<name>.png, "" removes the palette.
+// Extras keep their colours.
+func (view *View) SetPalette(worldX, worldY, worldZ int, name string) error {
+	if _, hasShape := view.Loader.GetShape(worldX, worldY, worldZ); !hasShape {
+		return fmt.Errorf("no shape at %d,%d,%d", worldX, worldY, worldZ)
+	}
+	if _, err := view.getPalette(name); err != nil {
+		return err
+	}
+	look := view.lookAt(worldX, worldY, worldZ)
+	look.Palette = name
+	view.updateLook(worldX, worldY, worldZ, look)
+	return nil
+}
+
+func (view *View) lookAt(worldX, worldY, worldZ int) world.Look {
+	if look := view.Loader.GetLook(worldX, worldY, worldZ); look != nil {
+		return *look
+	}
+	return world.Look{Tint: WHITE}
+}
+
+func (view *View) updateLook(worldX, worldY, worldZ int, look world.Look) {
+	var stored *world.Look
+	if look.Tint != WHITE || look.Palette != "" {
+		stored = &look
+	}
+	view.Loader.SetLook(worldX, worldY, worldZ, stored)
+	if blockPos := view.GetBlockPos(worldX, worldY, worldZ); blockPos != nil {
+		view.setLook(blockPos, stored)
+	}
+}
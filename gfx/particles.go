@@ -0,0 +1,467 @@
+package gfx
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/go-gl/gl/all-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/uzudil/isongn/util"
+	"github.com/uzudil/isongn/world"
+)
+
+// Particles are simulated on the gpu: the cpu only writes where and when each one starts, the
+// vertex shader moves it along and picks its colour from its age.
+const (
+	MAX_PARTICLES  = 10000 // per emitter
+	MAX_COLORS     = 8
+	PARTICLE_FLOAT = 8 // start xyz, velocity xyz, birth time, lifetime
+)
+
+// EmitterConfig is one entry of "particles" in config.json.
+type EmitterConfig struct {
+	Image string `json:"image"`
+	// x, y, w, h in pixels of the image, the whole image if not set
+	Region []int `json:"region"`
+	// w, h of a particle in world units
+	Size []float32 `json:"size"`
+	// particles per second
+	Rate float64 `json:"rate"`
+	// seconds: one value, or min and max
+	Lifetime []float64 `json:"lifetime"`
+	// world units per second: one xyz, or min and max xyz
+	Velocity [][]float32 `json:"velocity"`
+	// particles start this far from the emitter, xyz
+	Spread  []float32 `json:"spread"`
+	Gravity float32   `json:"gravity"`
+	// rgba 0-255 over the particle's life
+	Colors [][]float32 `json:"colors"`
+	// for weather: how high above the ground particles start
+	Height float32 `json:"height"`
+}
+
+func validateEmitter(name string, c *EmitterConfig, fail func(path, format string, args ...interface{})) {
+	path := "particles." + name
+	if c.Image == "" {
+		fail(path+".image", "missing required key")
+	}
+	if c.Region != nil && len(c.Region) != 4 {
+		fail(path+".region", "expected 4 values, got %d", len(c.Region))
+	}
+	if c.Size != nil && len(c.Size) != 2 {
+		fail(path+".size", "expected 2 values, got %d", len(c.Size))
+	}
+	if c.Rate <= 0 {
+		fail(path+".rate", "must be a positive number")
+	}
+	if len(c.Lifetime) < 1 || len(c.Lifetime) > 2 || c.Lifetime[0] <= 0 {
+		fail(path+".lifetime", "expected 1 or 2 positive numbers")
+	} else if c.Lifetime[len(c.Lifetime)-1] < c.Lifetime[0] {
+		fail(path+".lifetime", "expected the shortest lifetime first")
+	}
+	if len(c.Velocity) > 2 {
+		fail(path+".velocity", "expected 1 or 2 xyz values, got %d", len(c.Velocity))
+	}
+	for i, v := range c.Velocity {
+		if len(v) != 3 {
+			fail(fmt.Sprintf("%s.velocity[%d]", path, i), "expected 3 values, got %d", len(v))
+		}
+	}
+	if c.Spread != nil && len(c.Spread) != 3 {
+		fail(path+".spread", "expected 3 values, got %d", len(c.Spread))
+	}
+	if len(c.Colors) > MAX_COLORS {
+		fail(path+".colors", "at most %d colors, got %d", MAX_COLORS, len(c.Colors))
+	}
+	for i, color := range c.Colors {
+		if len(color) != 3 && len(color) != 4 {
+			fail(fmt.Sprintf("%s.colors[%d]", path, i), "expected 3 or 4 values, got %d", len(color))
+		}
+	}
+}
+
+// ParticleType is a loaded EmitterConfig
+type ParticleType struct {
+	texture     uint32
+	region      [4]float32
+	size        [2]float32
+	rate        float64
+	lifetime    [2]float64
+	velocity    [2][3]float32
+	spread      [3]float32
+	gravity     float32
+	colors      []float32
+	height      float32
+	maxLifetime float64
+}
+
+func loadParticleType(gameDir string, c *EmitterConfig) (*ParticleType, error) {
+	path := filepath.Join(gameDir, c.Image)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	texture, err := loadTexture(img)
+	if err != nil {
+		return nil, err
+	}
+	state.init = false
+
+	w, h := float32(img.Bounds().Dx()), float32(img.Bounds().Dy())
+	t := &ParticleType{
+		texture: texture,
+		region:  [4]float32{0, 0, 1, 1},
+		size:    [2]float32{0.25, 0.25},
+		rate:    c.Rate,
+		gravity: c.Gravity,
+		height:  c.Height,
+	}
+	if c.Region != nil {
+		t.region = [4]float32{float32(c.Region[0]) / w, float32(c.Region[1]) / h, float32(c.Region[2]) / w, float32(c.Region[3]) / h}
+	}
+	if c.Size != nil {
+		t.size = [2]float32{c.Size[0], c.Size[1]}
+	}
+	t.lifetime = [2]float64{c.Lifetime[0], c.Lifetime[len(c.Lifetime)-1]}
+	t.maxLifetime = t.lifetime[1]
+	for i := range t.velocity {
+		if len(c.Velocity) > 0 {
+			v := c.Velocity[util.MinInt(i, len(c.Velocity)-1)]
+			t.velocity[i] = [3]float32{v[0], v[1], v[2]}
+		}
+	}
+	if c.Spread != nil {
+		t.spread = [3]float32{c.Spread[0], c.Spread[1], c.Spread[2]}
+	}
+	if t.height == 0 {
+		t.height = world.SECTION_Z_SIZE
+	}
+	for _, color := range c.Colors {
+		a := float32(255)
+		if len(color) == 4 {
+			a = color[3]
+		}
+		t.colors = append(t.colors, color[0]/255, color[1]/255, color[2]/255, a/255)
+	}
+	if len(t.colors) == 0 {
+		t.colors = []float32{1, 1, 1, 1}
+	}
+	return t, nil
+}
+
+// Emitter spawns particles at a world position, or over the whole view for weather.
+type Emitter struct {
+	particleType *ParticleType
+	pos          [3]float32
+	weather      bool
+	stopped      bool
+	stoppedAt    float64
+	toSpawn      float64
+	next         int
+	data         []float32
+	vbo          uint32
+	changed      bool
+}
+
+type Particles struct {
+	program           uint32
+	vao               uint32
+	projectionUniform int32
+	cameraUniform     int32
+	viewScrollUniform int32
+	originUniform     int32
+	timeUniform       int32
+	gravityUniform    int32
+	sizeUniform       int32
+	rightUniform      int32
+	upUniform         int32
+	regionUniform     int32
+	colorsUniform     int32
+	colorCountUniform int32
+	daylightUniform   int32
+	textureUniform    int32
+	fogUniform        int32
+	fogEnabledUniform int32
+	startAttrib       uint32
+	velocityAttrib    uint32
+	birthAttrib       uint32
+	types             map[string]*ParticleType
+	emitters          map[int]*Emitter
+	emitterID         int
+}
+
+func (view *View) initParticles(configs map[string]*EmitterConfig) error {
+	p := &view.particles
+	var err error
+	p.program, err = NewProgram(particleVertexShader, particleFragmentShader)
+	if err != nil {
+		return err
+	}
+	gl.UseProgram(p.program)
+	p.projectionUniform = gl.GetUniformLocation(p.program, gl.Str("projection\x00"))
+	p.cameraUniform = gl.GetUniformLocation(p.program, gl.Str("camera\x00"))
+	p.viewScrollUniform = gl.GetUniformLocation(p.program, gl.Str("viewScroll\x00"))
+	p.originUniform = gl.GetUniformLocation(p.program, gl.Str("origin\x00"))
+	p.timeUniform = gl.GetUniformLocation(p.program, gl.Str("time\x00"))
+	p.gravityUniform = gl.GetUniformLocation(p.program, gl.Str("gravity\x00"))
+	p.sizeUniform = gl.GetUniformLocation(p.program, gl.Str("size\x00"))
+	p.rightUniform = gl.GetUniformLocation(p.program, gl.Str("right\x00"))
+	p.upUniform = gl.GetUniformLocation(p.program, gl.Str("up\x00"))
+	p.regionUniform = gl.GetUniformLocation(p.program, gl.Str("region\x00"))
+	p.colorsUniform = gl.GetUniformLocation(p.program, gl.Str("colors\x00"))
+	p.colorCountUniform = gl.GetUniformLocation(p.program, gl.Str("colorCount\x00"))
+	p.daylightUniform = gl.GetUniformLocation(p.program, gl.Str("daylight\x00"))
+	p.textureUniform = gl.GetUniformLocation(p.program, gl.Str("tex\x00"))
+	p.fogUniform = gl.GetUniformLocation(p.program, gl.Str("fog\x00"))
+	p.fogEnabledUniform = gl.GetUniformLocation(p.program, gl.Str("fogEnabled\x00"))
+	gl.BindFragDataLocation(p.program, 0, gl.Str("outputColor\x00"))
+	p.startAttrib = uint32(gl.GetAttribLocation(p.program, gl.Str("startPos\x00")))
+	p.velocityAttrib = uint32(gl.GetAttribLocation(p.program, gl.Str("velocity\x00")))
+	p.birthAttrib = uint32(gl.GetAttribLocation(p.program, gl.Str("birthLife\x00")))
+	gl.Uniform1i(p.textureUniform, 0)
+	gl.Uniform1i(p.fogUniform, 2)
+
+	// particles face the camera: its right and up in world space
+	right := view.camera.Row(0).Vec3()
+	up := view.camera.Row(1).Vec3()
+	gl.Uniform3fv(p.rightUniform, 1, &right[0])
+	gl.Uniform3fv(p.upUniform, 1, &up[0])
+
+	gl.GenVertexArrays(1, &p.vao)
+	gl.BindVertexArray(p.vao)
+	for _, attrib := range []uint32{p.startAttrib, p.velocityAttrib, p.birthAttrib} {
+		gl.EnableVertexAttribArray(attrib)
+		gl.VertexAttribDivisor(attrib, 1)
+	}
+	gl.BindVertexArray(view.vao)
+	gl.UseProgram(view.program)
+
+	p.types = map[string]*ParticleType{}
+	p.emitters = map[int]*Emitter{}
+	for name, c := range configs {
+		t, err := loadParticleType(view.gameDir, c)
+		if err != nil {
+			return fmt.Errorf("particles.%s: %v", name, err)
+		}
+		p.types[name] = t
+	}
+	return nil
+}
+
+func (view *View) startEmitter(name string, weather bool, x, y, z float32) (int, error) {
+	t, ok := view.particles.types[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown particles: %s", name)
+	}
+	capacity := util.MinInt(int(t.rate*t.maxLifetime)+1, MAX_PARTICLES)
+	e := &Emitter{
+		particleType: t,
+		pos:          [3]float32{x, y, z},
+		weather:      weather,
+		data:         make([]float32, capacity*PARTICLE_FLOAT),
+	}
+	gl.GenBuffers(1, &e.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, e.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(e.data)*4, gl.Ptr(e.data), gl.DYNAMIC_DRAW)
+	view.particles.emitterID++
+	view.particles.emitters[view.particles.emitterID] = e
+	return view.particles.emitterID, nil
+}
+
+// StartEmitter starts the named particles at a world position, returns the emitter's id.
+func (view *View) StartEmitter(name string, x, y, z float32) (int, error) {
+	return view.startEmitter(name, false, x, y, z)
+}
+
+// StartWeather starts the named particles over the whole view.
+func (view *View) StartWeather(name string) (int, error) {
+	return view.startEmitter(name, true, 0, 0, 0)
+}
+
+func (view *View) MoveEmitter(id int, x, y, z float32) bool {
+	if e, ok := view.particles.emitters[id]; ok {
+		e.pos = [3]float32{x, y, z}
+		return true
+	}
+	return false
+}
+
+// StopEmitter stops spawning, the emitter is removed once its particles are gone.
+func (view *View) StopEmitter(id int) bool {
+	if e, ok := view.particles.emitters[id]; ok && !e.stopped {
+		e.stopped = true
+		e.stoppedAt = state.time
+		return true
+	}
+	return false
+}
+
+func between(min, max float32) float32 {
+	return min + rand.Float32()*(max-min)
+}
+
+// spawn writes the particles started since the last frame
+func (view *View) spawn(e *Emitter) {
+	t := e.particleType
+	e.toSpawn += t.rate * state.delta
+	count := len(e.data) / PARTICLE_FLOAT
+	for ; e.toSpawn >= 1; e.toSpawn-- {
+		var x, y, z float32
+		if e.weather {
+			// anywhere on the ground which is on screen
+			r := view.drawRange[0]
+			x = between(float32(r[0]), float32(r[2])) + float32(view.loadedX-SIZE/2)
+			y = between(float32(r[1]), float32(r[3])) + float32(view.loadedY-SIZE/2)
+			z = t.height
+		} else {
+			x = e.pos[0] + between(-t.spread[0], t.spread[0])
+			y = e.pos[1] + between(-t.spread[1], t.spread[1])
+			z = e.pos[2] + between(-t.spread[2], t.spread[2])
+		}
+		life := float32(t.lifetime[0] + rand.Float64()*(t.lifetime[1]-t.lifetime[0]))
+		copy(e.data[e.next*PARTICLE_FLOAT:], []float32{
+			x, y, z,
+			between(t.velocity[0][0], t.velocity[1][0]),
+			between(t.velocity[0][1], t.velocity[1][1]),
+			between(t.velocity[0][2], t.velocity[1][2]),
+			float32(state.time), life,
+		})
+		e.next = (e.next + 1) % count
+		e.changed = true
+	}
+}
+
+func (view *View) drawParticles() {
+	p := &view.particles
+	if len(p.emitters) == 0 {
+		return
+	}
+	gl.UseProgram(p.program)
+	gl.BindVertexArray(p.vao)
+	gl.DepthMask(false)
+	gl.UniformMatrix4fv(p.projectionUniform, 1, false, &view.projection[0])
+	gl.UniformMatrix4fv(p.cameraUniform, 1, false, &view.camera[0])
+	gl.Uniform3fv(p.viewScrollUniform, 1, &view.ScrollOffset[0])
+	origin := mgl32.Vec3{-float32(view.loadedX), -float32(view.loadedY), 0}
+	gl.Uniform3fv(p.originUniform, 1, &origin[0])
+	gl.Uniform1f(p.timeUniform, float32(state.time))
+	gl.Uniform4fv(p.daylightUniform, 1, &view.daylight[0])
+	if view.fov.enabled {
+		gl.Uniform1i(p.fogEnabledUniform, 1)
+	} else {
+		gl.Uniform1i(p.fogEnabledUniform, 0)
+	}
+	for id, e := range p.emitters {
+		t := e.particleType
+		if e.stopped {
+			if state.time-e.stoppedAt > t.maxLifetime {
+				gl.DeleteBuffers(1, &e.vbo)
+				delete(p.emitters, id)
+				continue
+			}
+		} else {
+			view.spawn(e)
+		}
+		gl.BindBuffer(gl.ARRAY_BUFFER, e.vbo)
+		if e.changed {
+			gl.BufferSubData(gl.ARRAY_BUFFER, 0, len(e.data)*4, gl.Ptr(e.data))
+			e.changed = false
+		}
+		gl.VertexAttribPointer(p.startAttrib, 3, gl.FLOAT, false, PARTICLE_FLOAT*4, gl.PtrOffset(0))
+		gl.VertexAttribPointer(p.velocityAttrib, 3, gl.FLOAT, false, PARTICLE_FLOAT*4, gl.PtrOffset(3*4))
+		gl.VertexAttribPointer(p.birthAttrib, 2, gl.FLOAT, false, PARTICLE_FLOAT*4, gl.PtrOffset(6*4))
+		gl.BindTexture(gl.TEXTURE_2D, t.texture)
+		gl.Uniform1f(p.gravityUniform, t.gravity)
+		gl.Uniform2fv(p.sizeUniform, 1, &t.size[0])
+		gl.Uniform4fv(p.regionUniform, 1, &t.region[0])
+		gl.Uniform4fv(p.colorsUniform, int32(len(t.colors)/4), &t.colors[0])
+		gl.Uniform1i(p.colorCountUniform, int32(len(t.colors)/4))
+		gl.DrawArraysInstanced(gl.TRIANGLES, 0, 6, int32(len(e.data)/PARTICLE_FLOAT))
+	}
+	state.init = false
+	gl.DepthMask(true)
+	gl.BindVertexArray(view.vao)
+	gl.UseProgram(view.program)
+}
+
+var particleVertexShader = `
+#version 330
+uniform mat4 projection;
+uniform mat4 camera;
+uniform vec3 viewScroll;
+// world to view coordinates
+uniform vec3 origin;
+uniform float time;
+uniform float gravity;
+uniform vec2 size;
+uniform vec3 right;
+uniform vec3 up;
+// texture offset xy, size zw
+uniform vec4 region;
+// MAX_COLORS of them
+uniform vec4 colors[8];
+uniform int colorCount;
+in vec3 startPos;
+in vec3 velocity;
+// birth time, lifetime
+in vec2 birthLife;
+out vec2 fragTexCoord;
+out vec4 fragColor;
+out vec3 fragPos;
+const vec2 corners[6] = vec2[6](vec2(-0.5, -0.5), vec2(0.5, -0.5), vec2(0.5, 0.5), vec2(-0.5, -0.5), vec2(0.5, 0.5), vec2(-0.5, 0.5));
+void main() {
+	float age = time - birthLife.x;
+	if (age < 0 || age >= birthLife.y) {
+		// not born yet or dead: off screen
+		gl_Position = vec4(2, 2, 2, 1);
+		fragColor = vec4(0);
+		fragTexCoord = vec2(0);
+		fragPos = vec3(0);
+		return;
+	}
+	vec2 corner = corners[gl_VertexID];
+	vec3 pos = startPos + origin + velocity * age + vec3(0, 0, 0.5 * gravity * age * age);
+	pos += right * corner.x * size.x + up * corner.y * size.y;
+	fragPos = pos;
+	gl_Position = projection * camera * vec4(pos - viewScroll, 1);
+	fragTexCoord = region.xy + vec2(corner.x + 0.5, 0.5 - corner.y) * region.zw;
+
+	float f = (age / birthLife.y) * float(colorCount - 1);
+	int i = int(floor(f));
+	fragColor = mix(colors[i], colors[min(i + 1, colorCount - 1)], f - float(i));
+}
+` + "\x00"
+
+var particleFragmentShader = `
+#version 330
+uniform sampler2D tex;
+uniform vec4 daylight;
+// how visible each column of the view is, particles only show where the viewer sees now
+uniform sampler2D fog;
+uniform int fogEnabled;
+in vec2 fragTexCoord;
+in vec4 fragColor;
+in vec3 fragPos;
+layout(location = 0) out vec4 outputColor;
+void main() {
+	vec4 val = texture(tex, fragTexCoord) * fragColor;
+	if (val.a < 0.01) {
+		discard;
+	}
+	if (fogEnabled != 0) {
+		ivec2 cell = ivec2(floor(fragPos.xy)) + ivec2(48, 48);
+		if (texelFetch(fog, clamp(cell, ivec2(0), ivec2(95)), 0).r < 1.0) {
+			discard;
+		}
+	}
+	outputColor = val * vec4(daylight.rgb, 1);
+}
+` + "\x00"
@@ -0,0 +1,177 @@
+package gfx
+
+import (
+	"github.com/uzudil/isongn/util"
+	"github.com/uzudil/isongn/world"
+)
+
+// Roofs decides how high each column of the view is drawn. setMaxZ cuts the whole view, and with an
+// underShape only the columns under that group are drawn. The cutaway cuts only the building the
+// target is in: the connected shapes of one group above it. Both are cached per world column until
+// the map changes.
+type Roofs struct {
+	dirty bool
+	// columns under the underShape group at maxZ
+	under map[[2]int]bool
+	// the cutaway target, the roof found above it and the columns under that roof
+	cutaway bool
+	x, y, z int
+	roofZ   int
+	cut     map[[2]int]bool
+}
+
+// SetCutaway hides what is above the target, only in the building it's in.
+func (view *View) SetCutaway(worldX, worldY, worldZ int) {
+	roofs := &view.roofs
+	if !roofs.cutaway || roofs.x != worldX || roofs.y != worldY || roofs.z != worldZ {
+		roofs.cutaway = true
+		roofs.x, roofs.y, roofs.z = worldX, worldY, worldZ
+		roofs.dirty = true
+	}
+}
+
+func (view *View) ClearCutaway() {
+	view.roofs.cutaway = false
+	view.roofs.dirty = true
+}
+
+// roofChanged recomputes the roofs if the shape at this position can be part of one
+func (view *View) roofChanged(blockPos *BlockPos) {
+	if blockPos.block != nil && blockPos.block.shape.Group != 0 {
+		view.roofs.dirty = true
+	}
+}
+
+// topZ is the height the column at a view position is drawn to, 0 if it's hidden
+func (view *View) topZ(x, y int) int {
+	wx, wy, _ := view.toWorldPos(x, y, 0)
+	if view.underShape != nil && !view.roofs.under[[2]int{wx, wy}] {
+		return 0
+	}
+	if view.roofs.cut[[2]int{wx, wy}] && view.roofs.roofZ < view.maxZ {
+		return view.roofs.roofZ
+	}
+	return view.maxZ
+}
+
+func (view *View) updateRoofs() {
+	roofs := &view.roofs
+	if !roofs.dirty {
+		return
+	}
+	roofs.dirty = false
+
+	under := map[[2]int]bool{}
+	if view.underShape != nil && view.maxZ < world.SECTION_Z_SIZE {
+		groups := view.groupsAt(view.maxZ)
+		for x := 0; x < SIZE; x++ {
+			for y := 0; y < SIZE; y++ {
+				if groups[x][y] == view.underShape.Group {
+					wx, wy, _ := view.toWorldPos(x, y, 0)
+					under[[2]int{wx, wy}] = true
+				}
+			}
+		}
+	}
+	view.invalidateColumns(roofs.under, under)
+	roofs.under = under
+
+	cut := map[[2]int]bool{}
+	roofZ := 0
+	if roofs.cutaway {
+		cut, roofZ = view.findRoof(roofs.x, roofs.y, roofs.z)
+	}
+	if roofZ != roofs.roofZ {
+		view.invalidateColumns(roofs.cut, map[[2]int]bool{})
+		view.invalidateColumns(map[[2]int]bool{}, cut)
+	} else {
+		view.invalidateColumns(roofs.cut, cut)
+	}
+	roofs.cut = cut
+	roofs.roofZ = roofZ
+}
+
+// invalidateColumns rebakes the columns which are in only one of before and after
+func (view *View) invalidateColumns(before, after map[[2]int]bool) {
+	for pos := range before {
+		if !after[pos] {
+			view.invalidate(pos[0], pos[1])
+		}
+	}
+	for pos := range after {
+		if !before[pos] {
+			view.invalidate(pos[0], pos[1])
+		}
+	}
+}
+
+// groupsAt is the group of the shape covering each column of the view at level z, -1 if none
+func (view *View) groupsAt(z int) *[SIZE][SIZE]int {
+	groups := &[SIZE][SIZE]int{}
+	for x := 0; x < SIZE; x++ {
+		for y := 0; y < SIZE; y++ {
+			groups[x][y] = -1
+		}
+	}
+	for x := 0; x < SIZE; x++ {
+		for y := 0; y < SIZE; y++ {
+			for bz := 0; bz <= z; bz++ {
+				blockPos := view.at(x, y, bz)
+				if blockPos.block == nil {
+					continue
+				}
+				box := &blockPos.box
+				if z < box.Z || z >= box.Z+box.D {
+					continue
+				}
+				for bx := util.MaxInt(box.X, 0); bx < box.X+box.W && bx < SIZE; bx++ {
+					for by := util.MaxInt(box.Y, 0); by < box.Y+box.H && by < SIZE; by++ {
+						groups[bx][by] = blockPos.block.shape.Group
+					}
+				}
+			}
+		}
+	}
+	return groups
+}
+
+// findRoof finds the first grouped shape above the position, and the columns connected to it by the same group at that level
+func (view *View) findRoof(worldX, worldY, worldZ int) (map[[2]int]bool, int) {
+	cut := map[[2]int]bool{}
+	vx, vy, _, validPos := view.toViewPos(worldX, worldY, 0)
+	if !validPos {
+		return cut, 0
+	}
+	for z := worldZ + 1; z < world.SECTION_Z_SIZE; z++ {
+		roof := view.getShapeAt(vx, vy, z)
+		if roof == nil || roof.block.shape.Group == 0 {
+			continue
+		}
+		group := roof.block.shape.Group
+		groups := view.groupsAt(z)
+		queue := [][2]int{{vx, vy}}
+		seen := map[[2]int]bool{{vx, vy}: true}
+		for len(queue) > 0 {
+			pos := queue[0]
+			queue = queue[1:]
+			wx, wy, _ := view.toWorldPos(pos[0], pos[1], 0)
+			cut[[2]int{wx, wy}] = true
+			for _, d := range [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+				next := [2]int{pos[0] + d[0], pos[1] + d[1]}
+				if next[0] < 0 || next[0] >= SIZE || next[1] < 0 || next[1] >= SIZE || seen[next] {
+					continue
+				}
+				seen[next] = true
+				if groups[next[0]][next[1]] == group {
+					queue = append(queue, next)
+				}
+			}
+		}
+		// cut below the roof shape, it may start lower than where it was found
+		if roof.z > worldZ {
+			return cut, roof.z
+		}
+		return cut, z
+	}
+	return cut, 0
+}
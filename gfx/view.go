@@ -21,14 +21,20 @@ type Texture struct {
 // Block is a displayed Shape
 type Block struct {
 	vbo                 uint32
+	verts               []float32
 	sizeX, sizeY, sizeZ float32
 	shape               *shapes.Shape
 	texture             *Texture
 	index               int32
+	// has texels which are blended, drawn again in the translucent pass
+	translucent bool
 }
 
 const EXTRA_SIZE = 8
 
+// position xyz, texture coords, face normal xyz
+const VERTEX_FLOATS = 8
+
 var ZERO_OFFSET [2]float32
 
 type PathNode struct {
@@ -52,49 +58,94 @@ type BlockPos struct {
 	animationTimer         float64
 	animationType          int
 	animationSpeed         float64
+	animationStep          int
+	animationReverse       bool
+	animationDone          bool
+	look                   *world.Look
+	palette                *Palette
+	dynamic                bool
+	xray                   bool
 	ScrollOffset           [2]float32
 	pathNode               PathNode
 }
 
 type View struct {
-	width, height         int
-	Loader                *world.Loader
-	projection, camera    mgl32.Mat4
-	program               uint32
-	projectionUniform     int32
-	cameraUniform         int32
-	modelUniform          int32
-	textureUniform        int32
-	textureOffsetUniform  int32
-	alphaMinUniform       int32
-	daylightUniform       int32
-	viewScrollUniform     int32
-	modelScrollUniform    int32
-	timeUniform           int32
-	heightUniform         int32
-	uniqueOffsetUniform   int32
-	swayEnabledUniform    int32
-	bobEnabledUniform     int32
-	breatheEnabledUniform int32
-	vertAttrib            uint32
-	texCoordAttrib        uint32
-	textures              map[int]*Texture
-	blocks                []*Block
-	vao                   uint32
-	blockPos              [SIZE][SIZE][world.SECTION_Z_SIZE]*BlockPos
-	edges                 [SIZE][SIZE]*BlockPos
-	zoom                  float64
-	shear                 [3]float32
-	Cursor                *BlockPos
-	ScrollOffset          [3]float32
-	maxZ                  int
-	underShape            *shapes.Shape
-	daylight              [4]float32
+	width, height        int
+	Loader               *world.Loader
+	projection, camera   mgl32.Mat4
+	program              uint32
+	projectionUniform    int32
+	cameraUniform        int32
+	textureUniform       int32
+	daylightUniform      int32
+	translucentUniform   int32
+	paletteUniform       int32
+	paletteSizeUniform   int32
+	lightCountUniform    int32
+	lightPosUniform      int32
+	lightColorUniform    int32
+	sunDirUniform        int32
+	sunStrengthUniform   int32
+	fogUniform           int32
+	fogEnabledUniform    int32
+	viewScrollUniform    int32
+	timeUniform          int32
+	vertAttrib           uint32
+	texCoordAttrib       uint32
+	normalAttrib         uint32
+	instancePosAttrib    uint32
+	instanceParamsAttrib uint32
+	instanceTintAttrib   uint32
+	instanceScrollAttrib uint32
+	instances            *Instances
+	cursorInstances      *Instances
+	dirty                bool
+	originX, originY     int
+	loadedX, loadedY     int
+	loaded               bool
+	drawRange            DrawRange
+	maxShapeSize         [3]float32
+	bakedVao             uint32
+	regions              map[regionKey]*region
+	lights               map[int]*PointLight
+	lightID              int
+	shapeLights          map[*BlockPos]bool
+	sunDir               [3]float32
+	sunStrength          float32
+	fov                  Fov
+	xray                 Xray
+	roofs                Roofs
+	particles            Particles
+	textures             map[int]*Texture
+	palettes             map[string]*Palette
+	gameDir              string
+	blocks               []*Block
+	vao                  uint32
+	blockPos             [SIZE][SIZE][world.SECTION_Z_SIZE]*BlockPos
+	edges                [SIZE][SIZE]*BlockPos
+	zoom                 float64
+	zoomMin, zoomMax     float64
+	shear                [3]float32
+	Cursor               *BlockPos
+	ScrollOffset         [3]float32
+	maxZ                 int
+	underShape           *shapes.Shape
+	daylight             [4]float32
+	animationEvents      []AnimationEvent
+	// the positions with an animation set, stepped whether they're drawn or not
+	animated map[*BlockPos]bool
+	// zooming out further would show the ground past the grid
+	zoomGrid float64
+}
+
+// AnimationEvent is sent when a once or hold animation reaches its end.
+type AnimationEvent struct {
+	X, Y, Z int
+	Name    string
 }
 
 const viewSize = 10
 const SIZE = 96
-const DRAW_SIZE = 48
 const SEARCH_SIZE = 16
 
 func getProjection(zoom float32, shear [3]float32) mgl32.Mat4 {
@@ -107,7 +158,7 @@ func getProjection(zoom float32, shear [3]float32) mgl32.Mat4 {
 	return projection
 }
 
-func InitView(zoom float64, camera, shear [3]float32, loader *world.Loader) *View {
+func InitView(zoom float64, camera, shear [3]float32, loader *world.Loader, gameDir string) *View {
 	// does this have to be called in every file?
 	var err error
 	if err = gl.Init(); err != nil {
@@ -115,16 +166,24 @@ func InitView(zoom float64, camera, shear [3]float32, loader *world.Loader) *Vie
 	}
 
 	view := &View{
-		zoom:     zoom,
-		shear:    shear,
-		Loader:   loader,
-		maxZ:     world.SECTION_Z_SIZE,
-		daylight: [4]float32{1, 1, 1, 1},
+		zoom:        zoom,
+		zoomMin:     0.35,
+		zoomMax:     16,
+		shear:       shear,
+		Loader:      loader,
+		maxZ:        world.SECTION_Z_SIZE,
+		daylight:    [4]float32{1, 1, 1, 1},
+		palettes:    map[string]*Palette{},
+		gameDir:     gameDir,
+		lights:      map[int]*PointLight{},
+		animated:    map[*BlockPos]bool{},
+		shapeLights: map[*BlockPos]bool{},
 	}
-	view.projection = getProjection(float32(view.zoom), view.shear)
-
 	// coordinate system: Z is up
 	view.camera = mgl32.LookAtV(mgl32.Vec3{camera[0], camera[1], camera[2]}, mgl32.Vec3{0, 0, 0}, mgl32.Vec3{0, 0, 1})
+	view.zoomGrid = view.maxGridZoom()
+	view.zoom = math.Min(view.zoom, view.zoomGrid)
+	view.projection = getProjection(float32(view.zoom), view.shear)
 
 	// Configure the vertex and fragment shaders
 	view.program, err = NewProgram(vertexShader, fragmentShader)
@@ -135,41 +194,62 @@ func InitView(zoom float64, camera, shear [3]float32, loader *world.Loader) *Vie
 	gl.UseProgram(view.program)
 	view.projectionUniform = gl.GetUniformLocation(view.program, gl.Str("projection\x00"))
 	view.cameraUniform = gl.GetUniformLocation(view.program, gl.Str("camera\x00"))
-	view.modelUniform = gl.GetUniformLocation(view.program, gl.Str("model\x00"))
 	view.viewScrollUniform = gl.GetUniformLocation(view.program, gl.Str("viewScroll\x00"))
-	view.modelScrollUniform = gl.GetUniformLocation(view.program, gl.Str("modelScroll\x00"))
 	view.timeUniform = gl.GetUniformLocation(view.program, gl.Str("time\x00"))
-	view.heightUniform = gl.GetUniformLocation(view.program, gl.Str("height\x00"))
-	view.uniqueOffsetUniform = gl.GetUniformLocation(view.program, gl.Str("uniqueOffset\x00"))
-	view.swayEnabledUniform = gl.GetUniformLocation(view.program, gl.Str("swayEnabled\x00"))
-	view.bobEnabledUniform = gl.GetUniformLocation(view.program, gl.Str("bobEnabled\x00"))
-	view.breatheEnabledUniform = gl.GetUniformLocation(view.program, gl.Str("breatheEnabled\x00"))
 	view.textureUniform = gl.GetUniformLocation(view.program, gl.Str("tex\x00"))
-	view.textureOffsetUniform = gl.GetUniformLocation(view.program, gl.Str("textureOffset\x00"))
-	view.alphaMinUniform = gl.GetUniformLocation(view.program, gl.Str("alphaMin\x00"))
 	view.daylightUniform = gl.GetUniformLocation(view.program, gl.Str("daylight\x00"))
+	view.translucentUniform = gl.GetUniformLocation(view.program, gl.Str("translucentPass\x00"))
+	view.paletteUniform = gl.GetUniformLocation(view.program, gl.Str("palette\x00"))
+	view.paletteSizeUniform = gl.GetUniformLocation(view.program, gl.Str("paletteSize\x00"))
+	view.lightCountUniform = gl.GetUniformLocation(view.program, gl.Str("lightCount\x00"))
+	view.lightPosUniform = gl.GetUniformLocation(view.program, gl.Str("lightPos\x00"))
+	view.lightColorUniform = gl.GetUniformLocation(view.program, gl.Str("lightColor\x00"))
+	view.sunDirUniform = gl.GetUniformLocation(view.program, gl.Str("sunDir\x00"))
+	view.sunStrengthUniform = gl.GetUniformLocation(view.program, gl.Str("sunStrength\x00"))
+	view.fogUniform = gl.GetUniformLocation(view.program, gl.Str("fog\x00"))
+	view.fogEnabledUniform = gl.GetUniformLocation(view.program, gl.Str("fogEnabled\x00"))
 	gl.BindFragDataLocation(view.program, 0, gl.Str("outputColor\x00"))
 	view.vertAttrib = uint32(gl.GetAttribLocation(view.program, gl.Str("vert\x00")))
 	view.texCoordAttrib = uint32(gl.GetAttribLocation(view.program, gl.Str("vertTexCoord\x00")))
+	view.normalAttrib = uint32(gl.GetAttribLocation(view.program, gl.Str("vertNormal\x00")))
+	view.instancePosAttrib = uint32(gl.GetAttribLocation(view.program, gl.Str("instancePos\x00")))
+	view.instanceParamsAttrib = uint32(gl.GetAttribLocation(view.program, gl.Str("instanceParams\x00")))
+	view.instanceTintAttrib = uint32(gl.GetAttribLocation(view.program, gl.Str("instanceTint\x00")))
+	view.instanceScrollAttrib = uint32(gl.GetAttribLocation(view.program, gl.Str("instanceScroll\x00")))
 
 	gl.UniformMatrix4fv(view.projectionUniform, 1, false, &view.projection[0])
 	gl.UniformMatrix4fv(view.cameraUniform, 1, false, &view.camera[0])
 	gl.Uniform1i(view.textureUniform, 0)
+	gl.Uniform1i(view.paletteUniform, 1)
+	gl.Uniform1i(view.fogUniform, 2)
 
 	view.textures = map[int]*Texture{}
 	gl.GenVertexArrays(1, &view.vao)
+	gl.BindVertexArray(view.vao)
+	for _, attrib := range []uint32{view.instancePosAttrib, view.instanceParamsAttrib, view.instanceTintAttrib, view.instanceScrollAttrib} {
+		gl.EnableVertexAttribArray(attrib)
+		gl.VertexAttribDivisor(attrib, 1)
+	}
+	view.instances = NewInstances()
+	view.cursorInstances = NewInstances()
+	view.initBaking()
+	view.initFov()
+	view.initBlocks()
+	view.initGrid()
 
-	// create a block for each shape
-	view.blocks = []*Block{}
-	for index, shape := range shapes.Shapes {
-		if shape == nil {
-			view.blocks = append(view.blocks, nil)
-		} else {
-			view.blocks = append(view.blocks, view.newBlock(int32(index), shape))
-		}
+	view.Cursor = &BlockPos{
+		x:     0,
+		y:     0,
+		z:     0,
+		model: mgl32.Ident4(),
+		block: nil,
 	}
-	fmt.Printf("Created %d blocks.\n", len(view.blocks))
 
+	return view
+}
+
+// initGrid makes the cells of the view and its edges, in view position
+func (view *View) initGrid() {
 	for x := 0; x < SIZE; x++ {
 		for y := 0; y < SIZE; y++ {
 			for z := 0; z < world.SECTION_Z_SIZE; z++ {
@@ -208,16 +288,43 @@ func InitView(zoom float64, camera, shear [3]float32, loader *world.Loader) *Vie
 			}
 		}
 	}
+}
 
-	view.Cursor = &BlockPos{
-		x:     0,
-		y:     0,
-		z:     0,
-		model: mgl32.Ident4(),
-		block: nil,
+// create a block for each shape
+func (view *View) initBlocks() {
+	view.blocks = []*Block{}
+	view.maxShapeSize = [3]float32{1, 1, 1}
+	for index, shape := range shapes.Shapes {
+		if shape == nil {
+			view.blocks = append(view.blocks, nil)
+		} else {
+			view.blocks = append(view.blocks, view.newBlock(int32(index), shape))
+			for i := range view.maxShapeSize {
+				view.maxShapeSize[i] = float32(math.Max(float64(view.maxShapeSize[i]), float64(shape.Size[i])))
+			}
+		}
 	}
+	fmt.Printf("Created %d blocks.\n", len(view.blocks))
+}
 
-	return view
+// ReloadBlocks replaces the blocks and textures after shapes.Shapes changed. Call Reload() afterwards.
+func (view *View) ReloadBlocks() {
+	for _, b := range view.blocks {
+		if b != nil {
+			gl.DeleteBuffers(1, &b.vbo)
+		}
+	}
+	for _, tex := range view.textures {
+		gl.DeleteTextures(1, &tex.texture)
+	}
+	view.textures = map[int]*Texture{}
+	view.Cursor.block = nil
+	view.underShape = nil
+	state.init = false
+	view.dirty = true
+	view.deleteRegions()
+	view.shapeLights = map[*BlockPos]bool{}
+	view.initBlocks()
 }
 
 func (view *View) newBlock(index int32, shape *shapes.Shape) *Block {
@@ -234,8 +341,8 @@ func (view *View) newBlock(index int32, shape *shapes.Shape) *Block {
 
 	gl.GenBuffers(1, &b.vbo)
 	gl.BindBuffer(gl.ARRAY_BUFFER, b.vbo)
-	verts := b.vertices()
-	gl.BufferData(gl.ARRAY_BUFFER, len(verts)*4, gl.Ptr(verts), gl.STATIC_DRAW)
+	b.verts = b.vertices()
+	gl.BufferData(gl.ARRAY_BUFFER, len(b.verts)*4, gl.Ptr(b.verts), gl.STATIC_DRAW)
 
 	// load the texture if needed
 	tex, ok := view.textures[shape.ImageIndex]
@@ -251,6 +358,7 @@ func (view *View) newBlock(index int32, shape *shapes.Shape) *Block {
 		view.textures[shape.ImageIndex] = tex
 	}
 	b.texture = tex
+	b.translucent = hasTranslucentTexels(shapes.Images[shape.ImageIndex], shape)
 
 	return b
 }
@@ -316,6 +424,9 @@ func (b *Block) vertices() []float32 {
 
 	// scale and translate tex coords to within larger texture
 	for i := 0; i < 7; i++ {
+		if b.shape.Mirror {
+			points[i*5+3] = 1 - points[i*5+3]
+		}
 		points[i*5+3] *= b.shape.Tex.TexDim[0]
 		points[i*5+3] += b.shape.Tex.TexOffset[0]
 
@@ -326,13 +437,15 @@ func (b *Block) vertices() []float32 {
 	left := []int{0, 1, 2, 0, 2, 6}
 	right := []int{3, 4, 2, 2, 4, 6}
 	top := []int{5, 0, 4, 0, 6, 4}
+	normals := [][3]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
 
 	v := []float32{}
-	for _, side := range [][]int{left, right, top} {
+	for i, side := range [][]int{left, right, top} {
 		for _, idx := range side {
 			for t := 0; t < 5; t++ {
 				v = append(v, points[idx*5+t])
 			}
+			v = append(v, normals[i][0], normals[i][1], normals[i][2])
 		}
 	}
 	return v
@@ -340,50 +453,153 @@ func (b *Block) vertices() []float32 {
 
 func (view *View) SetMaxZ(z int) {
 	view.maxZ = z
+	view.roofs.dirty = true
+	view.invalidateAll()
 }
 
 func (view *View) SetUnderShape(shape *shapes.Shape) {
 	view.underShape = shape
+	view.roofs.dirty = true
+	view.invalidateAll()
 }
 
+// Load brings the view to the loader's position. Cells which stay in view are kept, with their
+// scroll offsets and animations, only the newly exposed strips are read from the loader.
 func (view *View) Load() {
-	// load
+	dx := view.Loader.X - view.loadedX
+	dy := view.Loader.Y - view.loadedY
+	if !view.loaded || util.AbsInt(dx) >= SIZE || util.AbsInt(dy) >= SIZE {
+		view.Reload()
+		return
+	}
+	if dx == 0 && dy == 0 {
+		return
+	}
+	view.shift(dx, dy)
 	view.traverse(func(x, y, z int) {
-		worldX, worldY, worldZ := view.toWorldPos(x, y, z)
-		blockPos := view.blockPos[x][y][z]
-
-		// reset
-		blockPos.block = nil
-		blockPos.ScrollOffset[0] = 0
-		blockPos.ScrollOffset[1] = 0
-		for i := 0; i < EXTRA_SIZE; i++ {
-			blockPos.extras[i] = nil
-		}
-		if z == 0 {
-			edge := view.edges[x][y]
-			edge.block = nil
+		if (dx > 0 && x >= SIZE-dx) || (dx < 0 && x < -dx) || (dy > 0 && y >= SIZE-dy) || (dy < 0 && y < -dy) {
+			blockPos := view.at(x, y, z)
+			blockPos.dir = 0
+			blockPos.animationType = 0
+			blockPos.animationSpeed = 0
+			blockPos.animationTimer = 0
+			blockPos.animationStep = 0
+			blockPos.animationReverse = false
+			blockPos.animationDone = false
+			view.loadCell(x, y, z)
 		}
-		blockPos.worldX = worldX
-		blockPos.worldY = worldY
-		blockPos.worldZ = worldZ
+	})
+	view.dirty = true
+	view.fov.dirty = true
+	view.xray.dirty = true
+	view.roofs.dirty = true
+}
 
-		shapeIndex, hasShape := view.Loader.GetShape(worldX, worldY, worldZ)
-		if hasShape {
-			view.setShapeInner(worldX, worldY, worldZ, shapeIndex, true)
+// Reload reads every cell from the loader again.
+func (view *View) Reload() {
+	view.loaded = true
+	view.loadedX = view.Loader.X
+	view.loadedY = view.Loader.Y
+	view.traverse(view.loadCell)
+	view.dirty = true
+	view.fov.dirty = true
+	view.xray.dirty = true
+	view.roofs.dirty = true
+}
+
+func (view *View) loadCell(x, y, z int) {
+	worldX, worldY, worldZ := view.toWorldPos(x, y, z)
+	blockPos := view.at(x, y, z)
+
+	// reset
+	blockPos.x = x
+	blockPos.y = y
+	blockPos.model.Set(0, 3, float32(x-SIZE/2))
+	blockPos.model.Set(1, 3, float32(y-SIZE/2))
+	blockPos.model.Set(2, 3, float32(z))
+	blockPos.block = nil
+	blockPos.look = nil
+	blockPos.palette = nil
+	blockPos.xray = false
+	delete(view.xray.occluders, blockPos)
+	view.trackLight(blockPos)
+	blockPos.ScrollOffset[0] = 0
+	blockPos.ScrollOffset[1] = 0
+	for i := 0; i < EXTRA_SIZE; i++ {
+		blockPos.extras[i] = nil
+	}
+	if z == 0 {
+		edge := view.edgeAt(x, y)
+		edge.x = x
+		edge.y = y
+		edge.model.Set(0, 3, float32(x-SIZE/2))
+		edge.model.Set(1, 3, float32(y-SIZE/2))
+		edge.block = nil
+	}
+	blockPos.worldX = worldX
+	blockPos.worldY = worldY
+	blockPos.worldZ = worldZ
+	if z == 0 {
+		view.invalidate(worldX, worldY)
+	}
+
+	shapeIndex, hasShape := view.Loader.GetShape(worldX, worldY, worldZ)
+	if hasShape && view.hasBlock(shapeIndex) {
+		view.setShapeInner(worldX, worldY, worldZ, shapeIndex, true)
+	}
+	extraIndex := 0
+	for _, shapeIndex := range view.Loader.GetExtras(worldX, worldY, worldZ) {
+		if extraIndex >= EXTRA_SIZE {
+			break
 		}
-		for i, shapeIndex := range view.Loader.GetExtras(worldX, worldY, worldZ) {
-			if i >= EXTRA_SIZE {
-				break
-			}
-			blockPos.extras[i] = view.blocks[shapeIndex]
+		if view.hasBlock(shapeIndex) {
+			blockPos.extras[extraIndex] = view.blocks[shapeIndex]
+			extraIndex++
 		}
+	}
+	if z == 0 {
+		shapeIndex, hasShape = view.Loader.GetEdge(worldX, worldY)
+		view.setEdgeInner(worldX, worldY, shapeIndex, hasShape && view.hasBlock(shapeIndex))
+	}
+}
+
+// shift moves the view's origin in the ring buffer by dx,dy and moves the cells still in view to their new view position
+func (view *View) shift(dx, dy int) {
+	view.originX = ((view.originX+dx)%SIZE + SIZE) % SIZE
+	view.originY = ((view.originY+dy)%SIZE + SIZE) % SIZE
+	view.loadedX += dx
+	view.loadedY += dy
+	view.traverse(func(x, y, z int) {
+		view.at(x, y, z).rebase(x, y, dx, dy)
 		if z == 0 {
-			shapeIndex, hasShape = view.Loader.GetEdge(worldX, worldY)
-			view.setEdgeInner(worldX, worldY, shapeIndex, hasShape)
+			view.edgeAt(x, y).rebase(x, y, dx, dy)
 		}
 	})
 }
 
+func (b *BlockPos) rebase(x, y, dx, dy int) {
+	b.x = x
+	b.y = y
+	b.model.Set(0, 3, b.model.At(0, 3)-float32(dx))
+	b.model.Set(1, 3, b.model.At(1, 3)-float32(dy))
+	b.box.X -= dx
+	b.box.Y -= dy
+}
+
+// at returns the cell at a view position, the grid is a ring buffer starting at originX,originY
+func (view *View) at(viewX, viewY, viewZ int) *BlockPos {
+	return view.blockPos[(viewX+view.originX)%SIZE][(viewY+view.originY)%SIZE][viewZ]
+}
+
+func (view *View) edgeAt(viewX, viewY int) *BlockPos {
+	return view.edges[(viewX+view.originX)%SIZE][(viewY+view.originY)%SIZE]
+}
+
+// maps may refer to shapes which were since removed from the config
+func (view *View) hasBlock(shapeIndex int) bool {
+	return shapeIndex >= 0 && shapeIndex < len(view.blocks) && view.blocks[shapeIndex] != nil
+}
+
 func (view *View) toWorldPos(viewX, viewY, viewZ int) (int, int, int) {
 	return viewX + (view.Loader.X - SIZE/2), viewY + (view.Loader.Y - SIZE/2), viewZ
 }
@@ -432,7 +648,7 @@ func (view *View) search(viewX, viewY, viewZ int, fx func(*BlockPos) bool) {
 				vy := viewY - y
 				vz := viewZ - z
 				if view.isValidViewPos(vx, vy, vz) {
-					bp := view.blockPos[vx][vy][vz]
+					bp := view.at(vx, vy, vz)
 					if bp.block != nil && fx(bp) {
 						return
 					}
@@ -473,7 +689,7 @@ func (view *View) GetBlocker(toWorldX, toWorldY, toWorldZ int, fromWorldX, fromW
 		print("WARN: View.GetBlocker src position invalid\n")
 		return nil
 	}
-	src := view.blockPos[viewX][viewY][viewZ]
+	src := view.at(viewX, viewY, viewZ)
 	if src.block == nil {
 		print("WARN: View.GetBlocker src position empty\n")
 		return nil
@@ -500,7 +716,7 @@ func (view *View) getBlockerAt(toViewX, toViewY, toViewZ int, box *BoundingBox,
 		// if bp.block.sizeZ > 1 {
 		// 	fmt.Printf("\tshape=%s at=%d,%d\n", bp.block.shape.Name, bp.x, bp.y)
 		// }
-		if bp != src && bp.box.intersect(box) {
+		if bp != src && bp.box.collides(box) {
 			blocker = bp
 			return true
 		}
@@ -515,7 +731,7 @@ func (view *View) IsEmpty(toWorldX, toWorldY, toWorldZ int, shape *shapes.Shape)
 	if !validPos {
 		return false
 	}
-	box := &BoundingBox{0, 0, 0, int(shape.Size[0]), int(shape.Size[1]), int(shape.Size[2])}
+	box := &BoundingBox{0, 0, 0, int(shape.Size[0]), int(shape.Size[1]), int(shape.Size[2]), shape.Mask}
 	return view.getBlockerAt(viewX, viewY, viewZ, box, nil) == nil
 }
 
@@ -523,11 +739,11 @@ func (view *View) FindTop(worldX, worldY int, shape *shapes.Shape) int {
 	maxZ := 0
 	viewX, viewY, _, validPos := view.toViewPos(worldX, worldY, maxZ)
 	if validPos {
-		box := &BoundingBox{0, 0, 0, int(shape.Size[0]), int(shape.Size[1]), int(shape.Size[2])}
+		box := &BoundingBox{0, 0, 0, int(shape.Size[0]), int(shape.Size[1]), int(shape.Size[2]), shape.Mask}
 		for z := view.maxZ - 1; z >= 0; z-- {
 			box.SetPos(viewX, viewY, z)
 			view.search(viewX+box.W, viewY+box.H, z+box.D, func(bp *BlockPos) bool {
-				if bp.box.intersect(box) && bp.z < view.maxZ && z+1 > maxZ {
+				if bp.box.collides(box) && bp.z < view.maxZ && z+1 > maxZ {
 					maxZ = z + 1
 				}
 				return false
@@ -550,9 +766,23 @@ func (view *View) MoveShape(worldX, worldY, worldZ, newWorldX, newWorldY int, is
 
 	// move
 	if bp != nil {
+		look := view.Loader.GetLook(worldX, worldY, worldZ)
 		blockPos, shapeIndex := view.EraseShapeExact(worldX, worldY, worldZ)
 		if blockPos != nil {
-			view.SetShape(newWorldX, newWorldY, bp.z, shapeIndex)
+			newBlockPos := view.SetShape(newWorldX, newWorldY, bp.z, shapeIndex)
+			view.Loader.SetLook(newWorldX, newWorldY, bp.z, look)
+			if newBlockPos != nil {
+				view.setLook(newBlockPos, look)
+				// keep animating from the same frame
+				newBlockPos.dir = blockPos.dir
+				newBlockPos.animationType = blockPos.animationType
+				newBlockPos.animationSpeed = blockPos.animationSpeed
+				newBlockPos.animationStep = blockPos.animationStep
+				newBlockPos.animationTimer = blockPos.animationTimer
+				newBlockPos.animationReverse = blockPos.animationReverse
+				newBlockPos.animationDone = blockPos.animationDone
+				view.animated[newBlockPos] = true
+			}
 		}
 		return bp.z
 	}
@@ -568,10 +798,16 @@ func (view *View) EraseShapeExact(worldX, worldY, worldZ int) (*BlockPos, int) {
 	viewX, viewY, viewZ, validPos := view.toViewPos(worldX, worldY, worldZ)
 	if validPos {
 		view.Loader.EraseShape(worldX, worldY, worldZ)
-		blockPos := view.blockPos[viewX][viewY][viewZ]
+		blockPos := view.at(viewX, viewY, viewZ)
 		if blockPos.block != nil {
 			shapeIndex := blockPos.block.shape.Index
+			view.fovChanged(blockPos)
+			view.roofChanged(blockPos)
+			view.xray.dirty = true
 			blockPos.block = nil
+			view.setLook(blockPos, nil)
+			view.trackLight(blockPos)
+			view.invalidate(worldX, worldY)
 			return blockPos, shapeIndex
 		}
 	}
@@ -593,17 +829,27 @@ func (view *View) EraseShape(worldX, worldY, worldZ int) (*BlockPos, int) {
 func (view *View) setShapeInner(worldX, worldY, worldZ int, shapeIndex int, hasShape bool) *BlockPos {
 	viewX, viewY, viewZ, validPos := view.toViewPos(worldX, worldY, worldZ)
 	if validPos {
-		blockPos := view.blockPos[viewX][viewY][viewZ]
+		view.invalidate(worldX, worldY)
+		blockPos := view.at(viewX, viewY, viewZ)
 		shape := shapes.Shapes[shapeIndex]
+		view.fovChanged(blockPos)
+		view.roofChanged(blockPos)
 		if hasShape {
 			blockPos.block = view.blocks[shapeIndex]
 			blockPos.model.Set(0, 3, float32(viewX-SIZE/2)+shape.Offset[0])
 			blockPos.model.Set(1, 3, float32(viewY-SIZE/2)+shape.Offset[1])
 			blockPos.model.Set(2, 3, float32(viewZ)+shape.Offset[2])
 			blockPos.box.Set(viewX, viewY, viewZ, int(blockPos.block.sizeX), int(blockPos.block.sizeY), int(blockPos.block.sizeZ))
+			blockPos.box.SetMask(shape.Mask)
+			view.setLook(blockPos, view.Loader.GetLook(worldX, worldY, worldZ))
 		} else {
 			blockPos.block = nil
+			view.setLook(blockPos, nil)
 		}
+		view.trackLight(blockPos)
+		view.fovChanged(blockPos)
+		view.roofChanged(blockPos)
+		view.xray.dirty = true
 
 		return blockPos
 	}
@@ -613,7 +859,7 @@ func (view *View) setShapeInner(worldX, worldY, worldZ int, shapeIndex int, hasS
 func (view *View) GetBlockPos(worldX, worldY, worldZ int) *BlockPos {
 	viewX, viewY, viewZ, validPos := view.toViewPos(worldX, worldY, worldZ)
 	if validPos {
-		return view.blockPos[viewX][viewY][viewZ]
+		return view.at(viewX, viewY, viewZ)
 	}
 	return nil
 }
@@ -621,17 +867,33 @@ func (view *View) GetBlockPos(worldX, worldY, worldZ int) *BlockPos {
 func (view *View) SetOffset(worldX, worldY, worldZ int, dx, dy float32) {
 	blockPos := view.GetBlockPos(worldX, worldY, worldZ)
 	if blockPos != nil {
+		baked := isBakedCell(blockPos)
 		blockPos.ScrollOffset[0] = dx
 		blockPos.ScrollOffset[1] = dy
+		// only instances known to move get their offset updated every frame
+		if baked || isBakedCell(blockPos) {
+			view.invalidate(worldX, worldY)
+		} else if !blockPos.dynamic {
+			view.dirty = true
+		}
 	}
 }
 
-func (view *View) SetShapeAnimation(worldX, worldY, worldZ int, animationType int, dir shapes.Direction, animationSpeed float64) {
+// SetShapeAnimation starts an animation, unless it's already playing. The animation starts phase steps in,
+// so creatures of the same kind don't all move in lockstep.
+func (view *View) SetShapeAnimation(worldX, worldY, worldZ int, animationType int, dir shapes.Direction, animationSpeed float64, phase int) {
 	blockPos := view.GetBlockPos(worldX, worldY, worldZ)
 	if blockPos != nil {
+		if blockPos.animationType != animationType || blockPos.dir == shapes.DIR_NONE || blockPos.animationDone {
+			blockPos.animationStep = phase
+			blockPos.animationTimer = animationSpeed
+			blockPos.animationReverse = false
+			blockPos.animationDone = false
+		}
 		blockPos.dir = dir
 		blockPos.animationType = animationType
 		blockPos.animationSpeed = animationSpeed
+		view.animated[blockPos] = true
 	}
 }
 
@@ -648,10 +910,11 @@ func (view *View) SetEdge(worldX, worldY int, shapeIndex int) {
 func (view *View) setEdgeInner(worldX, worldY int, shapeIndex int, hasShape bool) {
 	viewX, viewY, _, validPos := view.toViewPos(worldX, worldY, 0)
 	if validPos {
+		view.invalidate(worldX, worldY)
 		if hasShape {
-			view.edges[viewX][viewY].block = view.blocks[shapeIndex]
+			view.edgeAt(viewX, viewY).block = view.blocks[shapeIndex]
 		} else {
-			view.edges[viewX][viewY].block = nil
+			view.edgeAt(viewX, viewY).block = nil
 		}
 	}
 }
@@ -667,10 +930,11 @@ func (view *View) traverse(fx func(x, y, z int)) {
 }
 
 func (view *View) traverseForDraw(fx func(x, y, z int)) {
-	for x := -DRAW_SIZE / 2; x < DRAW_SIZE/2; x++ {
-		for y := -DRAW_SIZE / 2; y < DRAW_SIZE/2; y++ {
-			for z := 0; z < world.SECTION_Z_SIZE; z++ {
-				fx(x+SIZE/2, y+SIZE/2, z)
+	for z := 0; z < world.SECTION_Z_SIZE; z++ {
+		r := view.drawRange[z]
+		for x := r[0]; x < r[2]; x++ {
+			for y := r[1]; y < r[3]; y++ {
+				fx(x, y, z)
 			}
 		}
 	}
@@ -694,21 +958,9 @@ func (view *View) Scroll(dx, dy, dz float32) {
 	view.ScrollOffset[2] = dz
 }
 
-func (view *View) isUnderShape(x, y, z int) bool {
-	if view.underShape == nil {
-		return true
-	}
-	wx, wy, _ := view.toWorldPos(x, y, z)
-	if shapeIndex, _, _, _, hasShape := view.GetShape(wx, wy, view.maxZ); hasShape {
-		return shapes.Shapes[shapeIndex].Group == view.underShape.Group
-	}
-	return false
-}
-
 type DrawState struct {
 	init    bool
 	texture uint32
-	vbo     uint32
 	delta   float64
 	time    float64
 }
@@ -726,118 +978,195 @@ func (view *View) Draw(delta float64) {
 	gl.BindVertexArray(view.vao)
 	gl.EnableVertexAttribArray(view.vertAttrib)
 	gl.EnableVertexAttribArray(view.texCoordAttrib)
+	gl.EnableVertexAttribArray(view.normalAttrib)
 	gl.Uniform3fv(view.viewScrollUniform, 1, &view.ScrollOffset[0])
+	gl.Uniform3fv(view.sunDirUniform, 1, &view.sunDir[0])
+	gl.Uniform1f(view.sunStrengthUniform, view.sunStrength)
 	gl.Uniform4fv(view.daylightUniform, 1, &view.daylight[0])
 	state.delta = delta
 	state.time += delta
+	gl.Uniform1f(view.timeUniform, float32(state.time))
+	view.uploadLights()
+	if view.fov.enabled {
+		view.updateFov()
+		gl.Uniform1i(view.fogEnabledUniform, 1)
+	} else {
+		gl.Uniform1i(view.fogEnabledUniform, 0)
+	}
 	state.init = false
-	view.traverseForDraw(func(x, y, z int) {
-		blockPos := view.blockPos[x][y][z]
-		underShape := view.isUnderShape(x, y, z)
-		if blockPos.block != nil && z < view.maxZ && underShape {
-			blockPos.Draw(view, -1)
-		}
-		if z < view.maxZ && underShape {
-			modelZ := blockPos.model.At(2, 3)
-			for i := 0; i < EXTRA_SIZE; i++ {
-				if blockPos.extras[i] == nil {
-					break
-				}
-				// show extras slightly on top of each other
-				blockPos.model.Set(2, 3, modelZ+float32(i)*0.01)
-				blockPos.Draw(view, i)
-			}
-			blockPos.model.Set(2, 3, modelZ)
-		}
-		if z == 0 && underShape {
-			edge := view.edges[x][y]
-			if edge.block != nil {
-				edge.Draw(view, -1)
-			}
-		}
-	})
+	if drawRange := view.computeDrawRange(); drawRange != view.drawRange {
+		view.drawRange = drawRange
+		view.dirty = true
+		view.xray.dirty = true
+	}
+	view.updateXray()
+	view.updateRoofs()
+	view.bakeRegions()
+	if view.dirty {
+		view.buildInstances()
+		view.dirty = false
+	}
 	if view.Cursor.block != nil {
-		view.Cursor.Draw(view, -1)
+		view.cursorInstances.begin()
+		view.cursorInstances.add(view.Cursor, -1, view.Cursor.model.At(2, 3))
+		view.cursorInstances.end(view.towardsCamera())
 	}
-}
 
-func (b *BlockPos) Draw(view *View, extraIndex int) {
-	block := b.block
-	if extraIndex > -1 {
-		block = b.extras[extraIndex]
-	}
-	if !state.init || state.texture != block.texture.texture {
-		gl.BindTexture(gl.TEXTURE_2D, block.texture.texture)
-		state.texture = block.texture.texture
-	}
-	if !state.init || state.vbo != block.vbo {
-		gl.BindBuffer(gl.ARRAY_BUFFER, block.vbo)
-		gl.VertexAttribPointer(view.vertAttrib, 3, gl.FLOAT, false, 5*4, gl.PtrOffset(0))
-		gl.VertexAttribPointer(view.texCoordAttrib, 2, gl.FLOAT, false, 5*4, gl.PtrOffset(3*4))
-		state.vbo = block.vbo
-	}
-	gl.UniformMatrix4fv(view.modelUniform, 1, false, &b.model[0])
-	if extraIndex == -1 {
-		gl.Uniform2fv(view.modelScrollUniform, 1, &b.ScrollOffset[0])
-	} else {
-		gl.Uniform2fv(view.modelScrollUniform, 1, &ZERO_OFFSET[0])
-	}
-	gl.Uniform1f(view.alphaMinUniform, block.shape.AlphaMin)
-	gl.Uniform1f(view.timeUniform, float32(state.time))
-	gl.Uniform1f(view.heightUniform, block.shape.Size[2])
-	gl.Uniform1i(view.uniqueOffsetUniform, int32(b.worldX+b.worldY+b.worldZ))
-	if block.shape.SwayEnabled {
-		gl.Uniform1i(view.swayEnabledUniform, 1)
-	} else {
-		gl.Uniform1i(view.swayEnabledUniform, 0)
-	}
-	if block.shape.BobEnabled {
-		gl.Uniform1i(view.bobEnabledUniform, 1)
-	} else {
-		gl.Uniform1i(view.bobEnabledUniform, 0)
+	// the opaque texels in any order, then the translucent ones back to front over them
+	gl.Uniform1i(view.translucentUniform, 0)
+	view.drawBaked()
+	view.instances.drawOpaque(view)
+	if view.Cursor.block != nil {
+		view.cursorInstances.drawOpaque(view)
 	}
-	if block.shape.BreatheEnabled {
-		gl.Uniform1i(view.breatheEnabledUniform, 1)
-	} else {
-		gl.Uniform1i(view.breatheEnabledUniform, 0)
+	gl.Uniform1i(view.translucentUniform, 1)
+	gl.DepthMask(false)
+	view.instances.drawTranslucent(view)
+	if view.Cursor.block != nil {
+		view.cursorInstances.drawTranslucent(view)
 	}
+	gl.DepthMask(true)
+	view.drawParticles()
+}
 
-	animated := false
+// buildInstances collects what isn't baked, grouped by texture. The translucent instances are
+// also kept sorted back to front for the second pass.
+func (view *View) buildInstances() {
+	view.instances.begin()
+	view.traverseForDraw(func(x, y, z int) {
+		view.at(x, y, z).dynamic = false
+		// static shapes are baked
+		view.drawnAt(x, y, z, func(b *BlockPos, extraIndex int, block *Block, z float32, baked bool) {
+			if !baked {
+				view.instances.add(b, extraIndex, z)
+			}
+		})
+	})
+	view.instances.end(view.towardsCamera())
+}
+
+// textureOffset returns how far the current animation frame is from the block's first one
+func (b *BlockPos) textureOffset(block *Block) [2]float32 {
 	if b.dir != shapes.DIR_NONE {
 		if animation, ok := block.shape.Animations[b.animationType]; ok {
-			b.incrAnimationStep(animation)
 			if steps, ok := animation.Tex[b.dir]; ok {
-				gl.Uniform1f(view.textureOffsetUniform, steps[animation.AnimationStep].TexOffset[0])
-				animated = true
+				frame := steps[b.animationStep%len(steps)]
+				return [2]float32{
+					frame.TexOffset[0] - block.shape.Tex.TexOffset[0],
+					frame.TexOffset[1] - block.shape.Tex.TexOffset[1],
+				}
 			}
 		}
 	}
-	if !animated {
-		gl.Uniform1f(view.textureOffsetUniform, 0)
+	return ZERO_OFFSET
+}
+
+// Update steps the animations of every shape in view, drawn or not, so off-screen creatures
+// still finish their animations and send their events.
+func (view *View) Update(delta float64) {
+	for b := range view.animated {
+		if b.block == nil || b.dir == shapes.DIR_NONE {
+			delete(view.animated, b)
+			continue
+		}
+		animation, ok := b.block.shape.Animations[b.animationType]
+		if !ok {
+			continue
+		}
+		if steps, ok := animation.Tex[b.dir]; ok {
+			b.incrAnimationStep(view, animation, len(steps), delta)
+		}
 	}
-	gl.DrawArrays(gl.TRIANGLES, 0, 3*2*3)
-	state.init = true
 }
 
-func (b *BlockPos) incrAnimationStep(animation *shapes.Animation) {
-	b.animationTimer -= state.delta
-	if b.animationTimer <= 0 {
-		b.animationTimer = b.animationSpeed
-		animation.AnimationStep++
+func (b *BlockPos) incrAnimationStep(view *View, animation *shapes.Animation, steps int, delta float64) {
+	// sheet imports can have fewer steps in some directions
+	if b.animationStep >= steps {
+		b.animationStep %= steps
+	}
+	if b.animationDone {
+		return
+	}
+	b.animationTimer -= delta
+	if b.animationTimer > 0 {
+		return
+	}
+	step := b.animationStep + 1
+	if b.animationReverse {
+		step = b.animationStep - 1
+	}
+	if step < 0 || step >= steps {
+		switch animation.Mode {
+		case shapes.ANIMATION_LOOP:
+			step = 0
+		case shapes.ANIMATION_PINGPONG:
+			b.animationReverse = !b.animationReverse
+			step = 0
+			if b.animationReverse && steps > 1 {
+				step = steps - 2
+			} else if steps > 1 {
+				step = 1
+			}
+		case shapes.ANIMATION_ONCE:
+			step = 0
+			b.animationDone = true
+		case shapes.ANIMATION_HOLD:
+			step = steps - 1
+			b.animationDone = true
+		}
+		if b.animationDone {
+			view.animationEvents = append(view.animationEvents, AnimationEvent{b.worldX, b.worldY, b.worldZ, animation.Name})
+		}
 	}
-	if animation.AnimationStep >= animation.Steps {
-		animation.AnimationStep = 0
+	b.animationStep = step
+	b.animationTimer = b.animationSpeed
+	// a sheet can leave some frames untimed, those use the speed too
+	if durations, ok := animation.Durations[b.dir]; ok && step < len(durations) && durations[step] > 0 {
+		b.animationTimer = durations[step]
 	}
 }
 
+// AnimationEvents returns the animations which finished playing since the last call.
+func (view *View) AnimationEvents() []AnimationEvent {
+	events := view.animationEvents
+	view.animationEvents = nil
+	return events
+}
+
 func (view *View) Zoom(zoom float64) {
-	view.zoom = math.Min(math.Max(view.zoom-zoom*0.1, 0.35), 16)
+	view.SetZoom(view.zoom - zoom*0.1)
+}
+
+// clampZoom keeps a zoom between the zoom limits and to what the grid can fill
+func (view *View) clampZoom(zoom float64) float64 {
+	return math.Min(math.Max(zoom, view.zoomMin), math.Min(view.zoomMax, view.zoomGrid))
+}
+
+// SetZoom sets the zoom, kept between the zoom limits and to what the grid can fill.
+func (view *View) SetZoom(zoom float64) {
+	view.zoom = view.clampZoom(zoom)
 	// fmt.Printf("zoom:%f\n", view.zoom)
 	view.projection = getProjection(float32(view.zoom), view.shear)
 	gl.UseProgram(view.program)
 	gl.UniformMatrix4fv(view.projectionUniform, 1, false, &view.projection[0])
 }
 
+func (view *View) SetZoomLimits(min, max float64) {
+	view.zoomMin = math.Min(min, max)
+	view.zoomMax = math.Max(min, max)
+	view.SetZoom(view.zoom)
+}
+
+// SetSun sets the direction the sun shines from, and how much darker the faces turned away from it are (0-1).
+func (view *View) SetSun(dir [3]float32, strength float32) {
+	d := mgl32.Vec3(dir)
+	if d.Len() == 0 {
+		d = mgl32.Vec3{0, 0, 1}
+	}
+	view.sunDir = d.Normalize()
+	view.sunStrength = util.Clamp(strength, 0, 1)
+}
+
 func (view *View) SetDaylight(r, g, b, a float32) {
 	view.daylight[0] = r / 255
 	view.daylight[1] = g / 255
@@ -871,8 +1200,8 @@ func (view *View) FindPath(sx, sy, sz, ex, ey, ez int, isFlying bool) []PathStep
 */
 func (view *View) findPath(startViewX, startViewY, startViewZ, endViewX, endViewY, endViewZ, startWorldX, startWorldY, startWorldZ int, isFlying bool) []PathStep {
 	view.resetPathFind()
-	end := view.blockPos[endViewX][endViewY][endViewZ]
-	openList := []*BlockPos{view.blockPos[startViewX][startViewY][startViewZ]}
+	end := view.at(endViewX, endViewY, endViewZ)
+	openList := []*BlockPos{view.at(startViewX, startViewY, startViewZ)}
 	for len(openList) > 0 {
 		// Grab the lowest f(x) to process next
 		lowInd := 0
@@ -902,8 +1231,8 @@ func (view *View) findPath(startViewX, startViewY, startViewZ, endViewX, endView
 				// fmt.Printf("\ttrying %d,%d,%d\n", neighbor.x, neighbor.y, neighbor.z)
 				// g score is the shortest distance from start to current node, we need to check if
 				//   the path we have arrived at this neighbor is the shortest one we have seen yet
-				// adding 1: 1 is the distance from a node to it's neighbor
-				gScore := currentNode.pathNode.g + 1
+				// adding the cost of walking on the neighbor: 1, unless the shape under it says otherwise
+				gScore := currentNode.pathNode.g + view.walkCost(neighbor, startWorldX, startWorldY, startWorldZ)
 				gScoreIsBest := false
 
 				if !neighbor.pathNode.visited {
@@ -975,7 +1304,7 @@ func (view *View) tryMove(newViewX, newViewY, newViewZ, startWorldX, startWorldY
 	z := newViewZ
 	var standingOn *BlockPos
 	for z > 0 {
-		standingOn = view.getBlocker(view.blockPos[newViewX][newViewY][z-1], startWorldX, startWorldY, startWorldZ, cacheFit)
+		standingOn = view.getBlocker(view.at(newViewX, newViewY, z-1), startWorldX, startWorldY, startWorldZ, cacheFit)
 		if standingOn != nil {
 			break
 		}
@@ -985,17 +1314,17 @@ func (view *View) tryMove(newViewX, newViewY, newViewZ, startWorldX, startWorldY
 		return nil
 	}
 	if z < newViewZ {
-		return view.blockPos[newViewX][newViewY][z]
+		return view.at(newViewX, newViewY, z)
 	}
 
 	// same z move
-	newNode := view.blockPos[newViewX][newViewY][newViewZ]
+	newNode := view.at(newViewX, newViewY, newViewZ)
 	if view.getBlocker(newNode, startWorldX, startWorldY, startWorldZ, cacheFit) == nil {
 		return newNode
 	}
 
 	// step up?
-	newNode = view.blockPos[newViewX][newViewY][newViewZ+1]
+	newNode = view.at(newViewX, newViewY, newViewZ+1)
 	if view.getBlocker(newNode, startWorldX, startWorldY, startWorldZ, cacheFit) == nil {
 		return newNode
 	}
@@ -1018,6 +1347,15 @@ func (view *View) getBlocker(node *BlockPos, startWorldX, startWorldY, startWorl
 	}
 }
 
+func (view *View) walkCost(node *BlockPos, startWorldX, startWorldY, startWorldZ int) int {
+	if node.z > 0 {
+		if under := view.getBlocker(view.at(node.x, node.y, node.z-1), startWorldX, startWorldY, startWorldZ, true); under != nil {
+			return under.block.shape.WalkCost
+		}
+	}
+	return 1
+}
+
 func (view *View) generatePath(currentNode *BlockPos) []PathStep {
 	ret := []PathStep{}
 	for currentNode.pathNode.parent != nil {
@@ -1064,64 +1402,127 @@ var vertexShader = `
 #version 330
 uniform mat4 projection;
 uniform mat4 camera;
-uniform mat4 model;
-uniform float textureOffset;
 uniform vec3 viewScroll;
-uniform vec2 modelScroll;
 uniform float time;
-uniform float height;
-uniform int swayEnabled;
-uniform int bobEnabled;
-uniform int breatheEnabled;
-uniform int uniqueOffset;
 in vec3 vert;
 in vec2 vertTexCoord;
+in vec3 vertNormal;
+in vec3 instancePos;
+// height, alphaMin, uniqueOffset, flags
+in vec4 instanceParams;
+in vec4 instanceTint;
+// model scroll xy, texture offset zw
+in vec4 instanceScroll;
 out vec2 fragTexCoord;
+out vec3 fragPos;
+flat out vec3 fragNormal;
+flat out float fragAlphaMin;
+flat out vec4 fragTint;
+flat out int fragEmissive;
+flat out int fragXray;
+flat out int fragTranslucent;
 void main() {
-    fragTexCoord = vec2(vertTexCoord.x + textureOffset, vertTexCoord.y);
-
+	fragTexCoord = vertTexCoord + instanceScroll.zw;
+	fragAlphaMin = instanceParams.y;
+	fragTint = instanceTint;
+	fragNormal = vertNormal;
+
+	float height = instanceParams.x;
+	float uniqueOffset = instanceParams.z;
+	int flags = int(instanceParams.w);
 	float swayX = 0;
-	if(swayEnabled == 1) {
-		swayX = (vert.z / height) * sin(time + uniqueOffset) / 10.0;
-	}
 	float swayY = 0;
-	if(swayEnabled == 1) {
+	if((flags & 1) != 0) {
+		swayX = (vert.z / height) * sin(time + uniqueOffset) / 10.0;
 		swayY = (vert.z / height) * cos(time + uniqueOffset) / 10.0;
 	}
 	float bobZ = 0;
-	if(bobEnabled == 1) {
+	if((flags & 2) != 0) {
 		bobZ = cos((time + uniqueOffset) * 5.0) / 10.0;
-	}	
-	if(breatheEnabled == 1) {
+	}
+	if((flags & 4) != 0) {
 		bobZ = (vert.z / height) * cos((time + uniqueOffset) * 2.5) / 20.0;
-	}	
-	float offsX = modelScroll.x - viewScroll.x + swayX;
-	float offsY = modelScroll.y - viewScroll.y + swayY;
-	float offsZ = bobZ - viewScroll.z;
-
-	// matrix constructor is in column first order
-	mat4 modelScroll = mat4(
-		1.0, 0.0, 0.0, 0.0,
-		0.0, 1.0, 0.0, 0.0,
-		0.0, 0.0, 1.0, 0.0,
-		model[3][0] + offsX, model[3][1] + offsY, model[3][2] + offsZ, 1.0
+	}
+	fragEmissive = flags & 8;
+	fragXray = flags & 16;
+	fragTranslucent = flags & 32;
+	fragPos = vert + instancePos + vec3(instanceScroll.x + swayX, instanceScroll.y + swayY, bobZ);
+	vec3 offs = vec3(
+		instanceScroll.x - viewScroll.x + swayX,
+		instanceScroll.y - viewScroll.y + swayY,
+		bobZ - viewScroll.z
 	);
-    gl_Position = projection * camera * modelScroll * vec4(vert, 1);
+	gl_Position = projection * camera * vec4(vert + instancePos + offs, 1);
 }
 ` + "\x00"
 
 var fragmentShader = `
 #version 330
 uniform sampler2D tex;
-uniform float alphaMin;
 uniform vec4 daylight;
+uniform sampler2D palette;
+uniform int paletteSize;
+uniform int lightCount;
+// xyz, radius; MAX_LIGHTS of them
+uniform vec4 lightPos[32];
+uniform vec3 lightColor[32];
+uniform vec3 sunDir;
+uniform float sunStrength;
+// how visible each column of the view is, SIZE x SIZE
+uniform sampler2D fog;
+uniform int fogEnabled;
+// 1 draws only the blended texels of translucent instances, 0 everything else
+uniform int translucentPass;
 in vec2 fragTexCoord;
+in vec3 fragPos;
+flat in vec3 fragNormal;
+flat in float fragAlphaMin;
+flat in vec4 fragTint;
+flat in int fragEmissive;
+flat in int fragXray;
+flat in int fragTranslucent;
+// ordered dither for the shapes faded by xray
+const int bayer[16] = int[16](0, 8, 2, 10, 12, 4, 14, 6, 3, 11, 1, 9, 15, 7, 13, 5);
 layout(location = 0) out vec4 outputColor;
 void main() {
 	vec4 val = texture(tex, fragTexCoord);
-	if (val.a < alphaMin) {
+	if (val.a < fragAlphaMin) {
 		discard;
 	}
-	outputColor = val * daylight;
+	bool blended = fragTranslucent != 0 && val.a * fragTint.a < 1.0;
+	if (blended != (translucentPass != 0)) {
+		discard;
+	}
+	if (fragXray != 0 && bayer[(int(gl_FragCoord.y) % 4) * 4 + int(gl_FragCoord.x) % 4] >= 6) {
+		discard;
+	}
+	for (int i = 0; i < paletteSize; i++) {
+		if (distance(val.rgb, texelFetch(palette, ivec2(i, 0), 0).rgb) < 0.02) {
+			val.rgb = texelFetch(palette, ivec2(i, 1), 0).rgb;
+			break;
+		}
+	}
+	// faces turned away from the sun are darker
+	vec3 light = daylight.rgb * mix(1.0, max(dot(fragNormal, sunDir), 0.0), sunStrength);
+	if (fragEmissive != 0) {
+		light = vec3(1.0);
+	} else {
+		for (int i = 0; i < lightCount; i++) {
+			float d = distance(fragPos, lightPos[i].xyz) / lightPos[i].w;
+			if (d < 1.0) {
+				light += lightColor[i] * (1.0 - d) * (1.0 - d);
+			}
+		}
+	}
+	if (fogEnabled != 0) {
+		// step back from the face into the shape's own column; the view's middle is at 0,0
+		ivec2 cell = ivec2(floor(fragPos.xy - fragNormal.xy * 0.01)) + ivec2(48, 48);
+		float seen = texelFetch(fog, clamp(cell, ivec2(0), ivec2(95)), 0).r;
+		if (seen == 0.0) {
+			discard;
+		}
+		light = min(light, vec3(1.0)) * seen;
+	}
+	outputColor = val * fragTint * vec4(min(light, vec3(1.0)), daylight.a);
 }
 ` + "\x00"
@@ -0,0 +1,242 @@
+package gfx
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/uzudil/isongn/shapes"
+	"github.com/uzudil/isongn/world"
+)
+
+func TestIncrAnimationStep(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  shapes.AnimationMode
+		steps int
+		// the step after each update, and the updates which sent an AnimationDone event
+		expected []int
+		events   []int
+	}{
+		{"loop 1", shapes.ANIMATION_LOOP, 1, []int{0, 0, 0, 0, 0, 0}, nil},
+		{"loop 2", shapes.ANIMATION_LOOP, 2, []int{1, 0, 1, 0, 1, 0}, nil},
+		{"loop 3", shapes.ANIMATION_LOOP, 3, []int{1, 2, 0, 1, 2, 0}, nil},
+		{"pingpong 1", shapes.ANIMATION_PINGPONG, 1, []int{0, 0, 0, 0, 0, 0}, nil},
+		{"pingpong 2", shapes.ANIMATION_PINGPONG, 2, []int{1, 0, 1, 0, 1, 0}, nil},
+		{"pingpong 3", shapes.ANIMATION_PINGPONG, 3, []int{1, 2, 1, 0, 1, 2}, nil},
+		{"once 1", shapes.ANIMATION_ONCE, 1, []int{0, 0, 0, 0, 0, 0}, []int{0}},
+		{"once 2", shapes.ANIMATION_ONCE, 2, []int{1, 0, 0, 0, 0, 0}, []int{1}},
+		{"once 3", shapes.ANIMATION_ONCE, 3, []int{1, 2, 0, 0, 0, 0}, []int{2}},
+		{"hold 1", shapes.ANIMATION_HOLD, 1, []int{0, 0, 0, 0, 0, 0}, []int{0}},
+		{"hold 2", shapes.ANIMATION_HOLD, 2, []int{1, 1, 1, 1, 1, 1}, []int{1}},
+		{"hold 3", shapes.ANIMATION_HOLD, 3, []int{1, 2, 2, 2, 2, 2}, []int{2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			view := &View{}
+			animation := &shapes.Animation{Name: "walk", Steps: test.steps, Mode: test.mode}
+			b := &BlockPos{worldX: 1, worldY: 2, worldZ: 3, dir: shapes.DIR_S, animationSpeed: 1}
+			got, events := []int{}, []int{}
+			for i := range test.expected {
+				b.incrAnimationStep(view, animation, test.steps, 1)
+				got = append(got, b.animationStep)
+				for _, e := range view.AnimationEvents() {
+					if e != (AnimationEvent{1, 2, 3, "walk"}) {
+						t.Errorf("unexpected event %v", e)
+					}
+					events = append(events, i)
+				}
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got steps %v, expected %v", got, test.expected)
+			}
+			if len(events) > 0 || len(test.events) > 0 {
+				if !reflect.DeepEqual(events, test.events) {
+					t.Errorf("got events after updates %v, expected %v", events, test.events)
+				}
+			}
+		})
+	}
+}
+
+func TestAnimationDurations(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []float64
+		delta     float64
+		// the step and timer after each update
+		steps  []int
+		timers []float64
+	}{
+		{"speed", nil, 1, []int{1, 2, 0}, []float64{1, 1, 1}},
+		{"per step", []float64{0.5, 2, 3}, 1, []int{1, 1, 2, 2, 2, 0}, []float64{2, 1, 3, 2, 1, 0.5}},
+		{"untimed step", []float64{0.5, 0, 3}, 1, []int{1, 2, 2}, []float64{1, 3, 2}},
+		{"fewer durations", []float64{0.5}, 1, []int{1, 2, 0}, []float64{1, 1, 0.5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			view := &View{}
+			animation := &shapes.Animation{Name: "walk", Steps: 3, Durations: map[shapes.Direction][]float64{}}
+			if test.durations != nil {
+				animation.Durations[shapes.DIR_S] = test.durations
+			}
+			b := &BlockPos{dir: shapes.DIR_S, animationSpeed: 1}
+			steps, timers := []int{}, []float64{}
+			for range test.steps {
+				b.incrAnimationStep(view, animation, 3, test.delta)
+				steps = append(steps, b.animationStep)
+				timers = append(timers, b.animationTimer)
+			}
+			if !reflect.DeepEqual(steps, test.steps) || !reflect.DeepEqual(timers, test.timers) {
+				t.Errorf("got steps %v timers %v, expected %v %v", steps, timers, test.steps, test.timers)
+			}
+		})
+	}
+}
+
+func TestAnimationStepClamp(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     int
+		done     bool
+		expected int
+	}{
+		{"past the end", 5, false, 0},
+		{"past the end when done", 5, true, 2},
+		{"in range when done", 1, true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			animation := &shapes.Animation{Name: "walk", Steps: 3}
+			b := &BlockPos{dir: shapes.DIR_S, animationSpeed: 1, animationStep: test.step, animationDone: test.done}
+			// a sheet with 6 frames facing n but only 3 facing s
+			b.incrAnimationStep(&View{}, animation, 3, 1)
+			if b.animationStep != test.expected {
+				t.Errorf("got step %d, expected %d", b.animationStep, test.expected)
+			}
+		})
+	}
+}
+
+type testObserver struct{}
+
+func (o *testObserver) SectionLoad(x, y int, data map[string]interface{}) {}
+
+func (o *testObserver) SectionSave(x, y int) map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+// newTestView makes a view over an empty map without any gl resources, shapes are added to the loader
+func newTestView(t *testing.T, loader *world.Loader, blocks []*Block) *View {
+	view := &View{
+		Loader:      loader,
+		maxZ:        world.SECTION_Z_SIZE,
+		palettes:    map[string]*Palette{},
+		lights:      map[int]*PointLight{},
+		animated:    map[*BlockPos]bool{},
+		shapeLights: map[*BlockPos]bool{},
+		regions:     map[regionKey]*region{},
+		blocks:      blocks,
+	}
+	view.initGrid()
+	return view
+}
+
+func newTestLoader(t *testing.T) *world.Loader {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "maps"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	return world.NewLoader(&testObserver{}, dir, dir)
+}
+
+// sameCell compares what a cell was shifted to with what reloading it gives
+func sameCell(shifted, loaded *BlockPos) bool {
+	for i := 0; i < 3; i++ {
+		if shifted.model.At(i, 3) != loaded.model.At(i, 3) {
+			return false
+		}
+	}
+	if shifted.block != loaded.block || shifted.x != loaded.x || shifted.y != loaded.y {
+		return false
+	}
+	if shifted.worldX != loaded.worldX || shifted.worldY != loaded.worldY || shifted.worldZ != loaded.worldZ {
+		return false
+	}
+	return shifted.block == nil || shifted.box.X == loaded.box.X && shifted.box.Y == loaded.box.Y && shifted.box.Z == loaded.box.Z
+}
+
+func TestLoadShift(t *testing.T) {
+	defer func(old []*shapes.Shape) { shapes.Shapes = old }(shapes.Shapes)
+	shapes.Shapes = []*shapes.Shape{
+		{Index: 0, Name: "pillar", Size: [3]float32{1, 1, 2}},
+		{Index: 1, Name: "table", Size: [3]float32{2, 1, 1}, Offset: [3]float32{0.5, 0.25, 0}},
+	}
+	blocks := []*Block{
+		{shape: shapes.Shapes[0], sizeX: 1, sizeY: 1, sizeZ: 2},
+		{shape: shapes.Shapes[1], sizeX: 2, sizeY: 1, sizeZ: 1},
+	}
+	// the loader's section cache needs a running clock, keep the map and the view in one section
+	loader := newTestLoader(t)
+	for x := 5000; x < 5200; x++ {
+		for y := 5000; y < 5200; y++ {
+			if (x*7+y*13)%11 == 0 {
+				loader.SetShape(x, y, (x+y)%3, (x+y)%2)
+			}
+			if (x*5+y*3)%17 == 0 {
+				loader.SetEdge(x, y, 1)
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		start [2]int
+		moves [][2]int
+	}{
+		{"right", [2]int{5100, 5100}, [][2]int{{1, 0}}},
+		{"left", [2]int{5100, 5100}, [][2]int{{-1, 0}}},
+		{"down", [2]int{5100, 5100}, [][2]int{{0, 3}}},
+		{"up", [2]int{5100, 5100}, [][2]int{{0, -3}}},
+		{"diagonal", [2]int{5100, 5100}, [][2]int{{5, -7}, {-9, 2}}},
+		{"almost a whole view", [2]int{5052, 5148}, [][2]int{{SIZE - 1, 0}, {0, -(SIZE - 1)}}},
+		// the origin goes below 0 and past SIZE on both axes
+		{"wrap around", [2]int{5100, 5100}, [][2]int{{-50, 0}, {50, 40}, {50, -90}, {-95, 95}}},
+		{"too far", [2]int{5052, 5052}, [][2]int{{SIZE, 0}, {-SIZE, SIZE}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loader.MoveTo(test.start[0], test.start[1])
+			view := newTestView(t, loader, blocks)
+			view.Reload()
+			for _, move := range test.moves {
+				loader.MoveTo(loader.X+move[0], loader.Y+move[1])
+				view.Load()
+				if view.loadedX != loader.X || view.loadedY != loader.Y {
+					t.Fatalf("loaded %d,%d, expected %d,%d", view.loadedX, view.loadedY, loader.X, loader.Y)
+				}
+				reloaded := newTestView(t, loader, blocks)
+				reloaded.Reload()
+				view.traverse(func(x, y, z int) {
+					if t.Failed() {
+						return
+					}
+					shifted, loaded := view.at(x, y, z), reloaded.at(x, y, z)
+					if !sameCell(shifted, loaded) {
+						t.Errorf("after moving %v, cell %d,%d,%d is %+v, expected %+v", move, x, y, z, *shifted, *loaded)
+					}
+					if vx, vy, vz, ok := view.toViewPos(shifted.worldX, shifted.worldY, shifted.worldZ); !ok || vx != x || vy != y || vz != z {
+						t.Errorf("after moving %v, cell %d,%d,%d is at world %d,%d,%d which is view %d,%d,%d", move, x, y, z, shifted.worldX, shifted.worldY, shifted.worldZ, vx, vy, vz)
+					}
+					if z == 0 {
+						if edge := view.edgeAt(x, y); edge.block != reloaded.edgeAt(x, y).block || edge.x != x || edge.y != y ||
+							edge.model.At(0, 3) != reloaded.edgeAt(x, y).model.At(0, 3) || edge.model.At(1, 3) != reloaded.edgeAt(x, y).model.At(1, 3) {
+							t.Errorf("after moving %v, edge %d,%d differs from a reload", move, x, y)
+						}
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gfx
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/uzudil/isongn/util"
+	"github.com/uzudil/isongn/world"
+)
+
+// Xray fades the shapes which hide the target from the camera. Rays are cast from around the target
+// towards the camera, the shapes whose bounding box they cross are drawn dithered.
+type Xray struct {
+	enabled   bool
+	dirty     bool
+	x, y, z   int
+	occluders map[*BlockPos]bool
+}
+
+// the points around the target the rays start from, so a wall doesn't fade only where the middle ray hits it
+var xrayOrigins = [][3]float32{
+	{0.5, 0.5, 0.5}, {-0.5, 0.5, 0.5}, {1.5, 0.5, 0.5}, {0.5, -0.5, 0.5}, {0.5, 1.5, 0.5},
+	{0.5, 0.5, 1.5}, {-0.5, -0.5, 1.5}, {1.5, 1.5, 1.5}, {-0.5, 1.5, 1.5}, {1.5, -0.5, 1.5},
+}
+
+// SetXrayTarget fades what is between the camera and this world position.
+func (view *View) SetXrayTarget(worldX, worldY, worldZ int) {
+	xray := &view.xray
+	if !xray.enabled || xray.x != worldX || xray.y != worldY || xray.z != worldZ {
+		xray.enabled = true
+		xray.x, xray.y, xray.z = worldX, worldY, worldZ
+		xray.dirty = true
+	}
+}
+
+// ClearXrayTarget draws every shape solid again.
+func (view *View) ClearXrayTarget() {
+	view.xray.enabled = false
+	view.setOccluders(map[*BlockPos]bool{})
+}
+
+// towardsCamera is the direction from the scene to the camera, in view coordinates
+func (view *View) towardsCamera() mgl32.Vec3 {
+	inverse := view.projection.Mul4(view.camera).Inv()
+	near := mgl32.TransformCoordinate(mgl32.Vec3{0, 0, -1}, inverse)
+	far := mgl32.TransformCoordinate(mgl32.Vec3{0, 0, 1}, inverse)
+	return near.Sub(far).Normalize()
+}
+
+func (view *View) updateXray() {
+	xray := &view.xray
+	if !xray.enabled || !xray.dirty {
+		return
+	}
+	xray.dirty = false
+	occluders := map[*BlockPos]bool{}
+	vx, vy, vz, validPos := view.toViewPos(xray.x, xray.y, xray.z)
+	dir := view.towardsCamera()
+	if !validPos || dir.Z() <= 0 {
+		view.setOccluders(occluders)
+		return
+	}
+	target := view.getShapeAt(vx, vy, vz)
+
+	// the rays leave the grid once they're above the highest z level
+	reach := float32(world.SECTION_Z_SIZE-vz) / dir.Z()
+	margin := int(math.Max(float64(view.maxShapeSize[0]), float64(view.maxShapeSize[1]))) + 2
+	endX, endY := vx+int(dir.X()*reach), vy+int(dir.Y()*reach)
+	x0, x1 := clampView(util.MinInt(vx, endX)-margin), clampView(util.MaxInt(vx, endX)+margin)
+	y0, y1 := clampView(util.MinInt(vy, endY)-margin), clampView(util.MaxInt(vy, endY)+margin)
+	for x := x0; x < x1; x++ {
+		for y := y0; y < y1; y++ {
+			for z := util.MaxInt(0, vz-int(view.maxShapeSize[2])); z < world.SECTION_Z_SIZE; z++ {
+				blockPos := view.at(x, y, z)
+				if blockPos.block == nil || blockPos == target || blockPos.box.isInside(vx, vy, vz) {
+					continue
+				}
+				for _, o := range xrayOrigins {
+					origin := mgl32.Vec3{float32(vx) + o[0], float32(vy) + o[1], float32(vz) + o[2]}
+					if blockPos.box.hitByRay(origin, dir) {
+						occluders[blockPos] = true
+						break
+					}
+				}
+			}
+		}
+	}
+	view.setOccluders(occluders)
+}
+
+// setOccluders changes which shapes are faded, they're taken out of the baked regions
+func (view *View) setOccluders(occluders map[*BlockPos]bool) {
+	for blockPos := range view.xray.occluders {
+		if !occluders[blockPos] {
+			blockPos.xray = false
+			view.invalidate(blockPos.worldX, blockPos.worldY)
+		}
+	}
+	for blockPos := range occluders {
+		if !view.xray.occluders[blockPos] {
+			blockPos.xray = true
+			view.invalidate(blockPos.worldX, blockPos.worldY)
+		}
+	}
+	view.xray.occluders = occluders
+}
+
+// hitByRay is true if the ray from origin along dir crosses the box (slab test)
+func (bb *BoundingBox) hitByRay(origin, dir mgl32.Vec3) bool {
+	tMin, tMax := float32(0), float32(math.MaxFloat32)
+	min := [3]int{bb.X, bb.Y, bb.Z}
+	max := [3]int{bb.X + bb.W, bb.Y + bb.H, bb.Z + bb.D}
+	for i := 0; i < 3; i++ {
+		if dir[i] == 0 {
+			if origin[i] < float32(min[i]) || origin[i] >= float32(max[i]) {
+				return false
+			}
+			continue
+		}
+		t0 := (float32(min[i]) - origin[i]) / dir[i]
+		t1 := (float32(max[i]) - origin[i]) / dir[i]
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		if t0 > tMin {
+			tMin = t0
+		}
+		if t1 < tMax {
+			tMax = t1
+		}
+		if tMin > tMax {
+			return false
+		}
+	}
+	// starting inside the box doesn't count, the target is in it or in front of it
+	return tMin > 0
+}
@@ -0,0 +1,32 @@
+package gfx
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestHitByRay(t *testing.T) {
+	box := &BoundingBox{X: 2, Y: 2, Z: 0, W: 2, H: 2, D: 3}
+	tests := []struct {
+		name        string
+		origin, dir mgl32.Vec3
+		hit         bool
+	}{
+		{"through", mgl32.Vec3{0, 3, 1}, mgl32.Vec3{1, 0, 0}, true},
+		{"diagonal", mgl32.Vec3{0, 0, 1}, mgl32.Vec3{1, 1, 0.2}, true},
+		{"away", mgl32.Vec3{0, 3, 1}, mgl32.Vec3{-1, 0, 0}, false},
+		{"beside", mgl32.Vec3{0, 5, 1}, mgl32.Vec3{1, 0, 0}, false},
+		{"over", mgl32.Vec3{0, 3, 4}, mgl32.Vec3{1, 0, 0}, false},
+		{"up into it", mgl32.Vec3{3, 3, -2}, mgl32.Vec3{0, 0, 1}, true},
+		{"from inside", mgl32.Vec3{3, 3, 1}, mgl32.Vec3{1, 1, 1}, false},
+		{"short of it", mgl32.Vec3{0, 0, 1}, mgl32.Vec3{1, 5, 0}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if hit := box.hitByRay(test.origin, test.dir); hit != test.hit {
+				t.Errorf("got %v, expected %v", hit, test.hit)
+			}
+		})
+	}
+}
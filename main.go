@@ -13,6 +13,7 @@ import (
 	"runtime"
 
 	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/uzudil/isongn/catalog"
 	"github.com/uzudil/isongn/editor"
 	"github.com/uzudil/isongn/gfx"
 	"github.com/uzudil/isongn/runner"
@@ -26,14 +27,26 @@ func init() {
 
 func main() {
 	gameDir := flag.String("game", "game", "Location of the game assets directory")
-	mode := flag.String("mode", "runner", "Game or Editor mode")
+	mode := flag.String("mode", "runner", "Game, Editor or Catalog mode")
 	winWidth := flag.Int("width", 800, "Window width (default: 800)")
 	winHeight := flag.Int("height", 600, "Window height (default: 600)")
 	x := flag.Int("x", 5000, "Editor start X")
 	y := flag.Int("y", 5015, "Editor start Y")
 	fps := flag.Float64("fps", 60, "Frames per second")
+	catalogDir := flag.String("out", "catalog", "Catalog output directory")
 	flag.Parse()
 
+	if *mode == "catalog" {
+		sheets, creatures, err := gfx.LoadShapeConfig(*gameDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := catalog.Write(*gameDir, *catalogDir, sheets, creatures); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if err := glfw.Init(); err != nil {
 		log.Fatalln("failed to initialize glfw:", err)
 	}
@@ -47,7 +60,7 @@ func main() {
 	} else if *mode == runner.Name() {
 		game = runner
 	} else {
-		fmt.Println("mode must be 'runner' or 'editor'")
+		fmt.Println("mode must be 'runner', 'editor' or 'catalog'")
 		os.Exit(1)
 	}
 	script.InitScript()
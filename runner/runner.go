@@ -42,12 +42,18 @@ type Runner struct {
 	sectionSaveCall    *bscript.Variable
 	sectionSaveXArg    *bscript.Value
 	sectionSaveYArg    *bscript.Value
+	animationDoneCall  *bscript.Variable
+	animationDoneXArg  *bscript.Value
+	animationDoneYArg  *bscript.Value
+	animationDoneZArg  *bscript.Value
+	animationNameArg   *bscript.Value
 	messages           map[int]*Message
 	messageIndex       int
 	updateOverlay      bool
 	Calendar           *Calendar
 	positionMessages   []*PositionMessage
 	daylight           [24][3]float32
+	sun                *Sun
 	lastHour           int
 }
 
@@ -91,6 +97,10 @@ func (runner *Runner) Init(app *gfx.App, config map[string]interface{}) {
 				}
 			}
 		}
+
+		if sun, ok := cal["sun"].(map[string]interface{}); ok {
+			runner.sun = NewSun(sun)
+		}
 	} else {
 		runner.Calendar = NewCalendar(0, 9, 1, 5, 1992, 0.1)
 	}
@@ -131,6 +141,12 @@ func (runner *Runner) Init(app *gfx.App, config map[string]interface{}) {
 	runner.sectionSaveYArg = &bscript.Value{Number: &bscript.SignedNumber{}}
 	runner.sectionSaveCall = util.NewFunctionCall("beforeSectionSave", runner.sectionSaveXArg, runner.sectionSaveYArg)
 
+	runner.animationDoneXArg = &bscript.Value{Number: &bscript.SignedNumber{}}
+	runner.animationDoneYArg = &bscript.Value{Number: &bscript.SignedNumber{}}
+	runner.animationDoneZArg = &bscript.Value{Number: &bscript.SignedNumber{}}
+	runner.animationNameArg = &bscript.Value{}
+	runner.animationDoneCall = util.NewFunctionCall("onAnimationDone", runner.animationDoneXArg, runner.animationDoneYArg, runner.animationDoneZArg, runner.animationNameArg)
+
 	// run the main method
 	_, err = ast.Evaluate(ctx)
 	if err != nil {
@@ -168,6 +184,14 @@ func (runner *Runner) SectionSave(x, y int) map[string]interface{} {
 	return ret.(map[string]interface{})
 }
 
+func (runner *Runner) AnimationDone(worldX, worldY, worldZ int, name string) {
+	runner.animationDoneXArg.Number.Number = float64(worldX)
+	runner.animationDoneYArg.Number.Number = float64(worldY)
+	runner.animationDoneZArg.Number.Number = float64(worldZ)
+	runner.animationNameArg.String = &name
+	runner.animationDoneCall.Evaluate(runner.ctx)
+}
+
 func (runner *Runner) overlayContents(panel *gfx.Panel) bool {
 	if runner.updateOverlay {
 		panel.Clear()
@@ -259,6 +283,9 @@ func (runner *Runner) MinsChange(mins, hours, day, month, year int) {
 		util.Linear(nowColor[2], nextColor[2], percent),
 		255,
 	)
+	if runner.sun != nil {
+		runner.app.View.SetSun(runner.sun.direction(float64(hours)+float64(mins)/60), runner.sun.strength)
+	}
 	time := ToEpoch(mins, hours, day, month, year)
 	if time-runner.lastHour > 60 {
 		runner.lastHour = time
@@ -0,0 +1,43 @@
+package runner
+
+import "math"
+
+// Sun moves from shining on the left faces at sunrise to the right faces at sunset, highest at midday.
+// At night the light comes from above.
+type Sun struct {
+	rise, set float64
+	elevation float64
+	strength  float32
+}
+
+func NewSun(config map[string]interface{}) *Sun {
+	sun := &Sun{rise: 6, set: 20, elevation: 60, strength: 0.4}
+	if v, ok := config["rise"].(float64); ok {
+		sun.rise = v
+	}
+	if v, ok := config["set"].(float64); ok {
+		sun.set = v
+	}
+	if v, ok := config["elevation"].(float64); ok {
+		sun.elevation = v
+	}
+	if v, ok := config["strength"].(float64); ok {
+		sun.strength = float32(v)
+	}
+	return sun
+}
+
+// direction is where the sun shines from at the hour (0-24)
+func (sun *Sun) direction(hour float64) [3]float32 {
+	if sun.set <= sun.rise || hour < sun.rise || hour > sun.set {
+		return [3]float32{0, 0, 1}
+	}
+	day := (hour - sun.rise) / (sun.set - sun.rise)
+	azimuth := day * math.Pi / 2
+	elevation := sun.elevation * math.Sin(day*math.Pi) * math.Pi / 180
+	return [3]float32{
+		float32(math.Cos(azimuth) * math.Cos(elevation)),
+		float32(math.Sin(azimuth) * math.Cos(elevation)),
+		float32(math.Sin(elevation)),
+	}
+}
@@ -0,0 +1,34 @@
+package runner
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSunDirection(t *testing.T) {
+	sun := NewSun(map[string]interface{}{"rise": 6.0, "set": 18.0, "elevation": 90.0})
+	tests := []struct {
+		name     string
+		sun      *Sun
+		hour     float64
+		expected [3]float32
+	}{
+		{"night", sun, 2, [3]float32{0, 0, 1}},
+		{"after sunset", sun, 19, [3]float32{0, 0, 1}},
+		{"sunrise", sun, 6, [3]float32{1, 0, 0}},
+		{"midday", sun, 12, [3]float32{0, 0, 1}},
+		{"sunset", sun, 18, [3]float32{0, 1, 0}},
+		{"morning", NewSun(map[string]interface{}{"rise": 6.0, "set": 18.0, "elevation": 0.0}), 9, [3]float32{float32(math.Cos(math.Pi / 8)), float32(math.Sin(math.Pi / 8)), 0}},
+		{"sets before it rises", NewSun(map[string]interface{}{"rise": 20.0, "set": 6.0}), 12, [3]float32{0, 0, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.sun.direction(test.hour)
+			for i := range got {
+				if math.Abs(float64(got[i]-test.expected[i])) > 1e-5 {
+					t.Fatalf("got %v, expected %v", got, test.expected)
+				}
+			}
+		})
+	}
+}
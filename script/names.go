@@ -0,0 +1,88 @@
+package script
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/uzudil/bscript/bscript"
+	"github.com/uzudil/isongn/shapes"
+	"github.com/uzudil/isongn/util"
+)
+
+// how many close matches to suggest for an unknown name
+const maxSuggestions = 3
+
+// resolveShape is how builtins find a shape by name: an unknown name is an error, not shape 0.
+func resolveShape(ctx *bscript.Context, name string) (*shapes.Shape, error) {
+	if index, ok := shapes.Names[name]; ok && shapes.Shapes[index] != nil {
+		return shapes.Shapes[index], nil
+	}
+	return nil, unknownName(ctx, "shape", name, shapes.Names)
+}
+
+func resolveAnimation(ctx *bscript.Context, name string) (int, error) {
+	if index, ok := shapes.AnimationNames[name]; ok {
+		return index, nil
+	}
+	return 0, unknownName(ctx, "animation", name, shapes.AnimationNames)
+}
+
+func unknownName(ctx *bscript.Context, kind, name string, names map[string]int) error {
+	suggestions := closeMatches(name, names)
+	if len(suggestions) == 0 {
+		return fmt.Errorf("%s unknown %s: %s", ctx.Pos, kind, name)
+	}
+	return fmt.Errorf("%s unknown %s: %s (did you mean %s?)", ctx.Pos, kind, name, strings.Join(suggestions, ", "))
+}
+
+func closeMatches(name string, names map[string]int) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+	maxDistance := len(name) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	lower := strings.ToLower(name)
+	matches := []match{}
+	for candidate := range names {
+		d := levenshtein(lower, strings.ToLower(candidate))
+		if d <= maxDistance {
+			matches = append(matches, match{candidate, d})
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].distance != matches[b].distance {
+			return matches[a].distance < matches[b].distance
+		}
+		return matches[a].name < matches[b].name
+	})
+	r := []string{}
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		r = append(r, matches[i].name)
+	}
+	return r
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = util.MinInt(util.MinInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
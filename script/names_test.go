@@ -0,0 +1,54 @@
+package script
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCloseMatches(t *testing.T) {
+	names := map[string]int{
+		"tree.oak":   0,
+		"tree.pine":  1,
+		"tree.palm":  2,
+		"tree.plum":  3,
+		"wall.stone": 4,
+		"door":       5,
+	}
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"tree.oka", []string{"tree.oak"}},
+		{"Tree.Oak", []string{"tree.oak"}},
+		{"tree.plm", []string{"tree.palm", "tree.plum"}},
+		{"tree.pam", []string{"tree.palm", "tree.oak", "tree.plum"}},
+		{"tree.", []string{}},
+		{"dor", []string{"door"}},
+		{"castle", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := closeMatches(test.name, names); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"tree", "tree", 0},
+	}
+	for _, test := range tests {
+		if got := levenshtein(test.a, test.b); got != test.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, expected %d", test.a, test.b, got, test.distance)
+		}
+	}
+}
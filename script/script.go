@@ -49,8 +49,14 @@ func intersectsShapes(ctx *bscript.Context, arg ...interface{}) (interface{}, er
 	z := int(arg[2].(float64))
 	nameA := arg[3].(string)
 	nameB := arg[4].(string)
-	shapeA := shapes.Shapes[shapes.Names[nameA]]
-	shapeB := shapes.Shapes[shapes.Names[nameB]]
+	shapeA, err := resolveShape(ctx, nameA)
+	if err != nil {
+		return nil, err
+	}
+	shapeB, err := resolveShape(ctx, nameB)
+	if err != nil {
+		return nil, err
+	}
 
 	app := ctx.App["app"].(*gfx.App)
 
@@ -78,7 +84,10 @@ func setMaxZ(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	app := ctx.App["app"].(*gfx.App)
 	app.View.SetMaxZ(int(f))
 	if roofName, ok := arg[1].(string); ok {
-		roof := shapes.Shapes[shapes.Names[roofName]]
+		roof, err := resolveShape(ctx, roofName)
+		if err != nil {
+			return nil, err
+		}
 		app.View.SetUnderShape(roof)
 	} else {
 		app.View.SetUnderShape(nil)
@@ -86,6 +95,21 @@ func setMaxZ(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	return nil, nil
 }
 
+func setCutaway(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	x := int(arg[0].(float64))
+	y := int(arg[1].(float64))
+	z := int(arg[2].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	app.View.SetCutaway(x, y, z)
+	return nil, nil
+}
+
+func clearCutaway(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.View.ClearCutaway()
+	return nil, nil
+}
+
 func print(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	fmt.Printf("%v\n", arg[0])
 	return nil, nil
@@ -105,18 +129,85 @@ func setShape(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	y := int(arg[1].(float64))
 	z := int(arg[2].(float64))
 	name := arg[3].(string)
+	shape, err := resolveShape(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 	app := ctx.App["app"].(*gfx.App)
-	app.View.SetShape(x, y, z, shapes.Names[name])
+	app.View.SetShape(x, y, z, shape.Index)
 	return nil, nil
 }
 
+// setShapeVariant places one of the shape's variants, picked by position, and returns its name
+func setShapeVariant(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	x := int(arg[0].(float64))
+	y := int(arg[1].(float64))
+	z := int(arg[2].(float64))
+	name := arg[3].(string)
+	shape, err := resolveShape(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	placed := shape.PickVariant(x, y, z)
+	app := ctx.App["app"].(*gfx.App)
+	app.View.SetShape(x, y, z, placed.Index)
+	return placed.Name, nil
+}
+
+// getShapeInfo returns what the config says about a shape
+func getShapeInfo(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	name := arg[0].(string)
+	shape, err := resolveShape(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	size := []interface{}{float64(shape.Size[0]), float64(shape.Size[1]), float64(shape.Size[2])}
+	return map[string]interface{}{
+		"name":  shape.Name,
+		"size":  &size,
+		"group": float64(shape.Group),
+		"flags": map[string]interface{}{
+			"sway":      shape.SwayEnabled,
+			"bob":       shape.BobEnabled,
+			"breathe":   shape.BreatheEnabled,
+			"nosupport": shape.NoSupport,
+			"extra":     shape.IsExtra,
+			"emissive":  shape.Emissive,
+		},
+		"props": toScriptValue(shape.Props),
+	}, nil
+}
+
+// json arrays become array pointers, the way bscript keeps them
+func toScriptValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, e := range t {
+			a[i] = toScriptValue(e)
+		}
+		return &a
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = toScriptValue(e)
+		}
+		return m
+	}
+	return v
+}
+
 func setShapeExtra(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	x := int(arg[0].(float64))
 	y := int(arg[1].(float64))
 	z := int(arg[2].(float64))
 	name := arg[3].(string)
+	shape, err := resolveShape(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 	app := ctx.App["app"].(*gfx.App)
-	app.Loader.AddExtra(x, y, z, shapes.Names[name])
+	app.Loader.AddExtra(x, y, z, shape.Index)
 	return nil, nil
 }
 
@@ -164,6 +255,134 @@ func setOffset(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	return nil, nil
 }
 
+// setTint multiplies the colours of the shape, not its extras, rgba are 0-255
+func setTint(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	x := int(arg[0].(float64))
+	y := int(arg[1].(float64))
+	z := int(arg[2].(float64))
+	r := float32(arg[3].(float64))
+	g := float32(arg[4].(float64))
+	b := float32(arg[5].(float64))
+	a := float32(arg[6].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	if err := app.View.SetTint(x, y, z, r, g, b, a); err != nil {
+		return nil, fmt.Errorf("%s %v", ctx.Pos, err)
+	}
+	return nil, nil
+}
+
+// setPalette remaps the colours of the shape, not its extras, with palettes/<name>.png, null removes the palette
+func setPalette(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	x := int(arg[0].(float64))
+	y := int(arg[1].(float64))
+	z := int(arg[2].(float64))
+	name, _ := arg[3].(string)
+	app := ctx.App["app"].(*gfx.App)
+	if err := app.View.SetPalette(x, y, z, name); err != nil {
+		return nil, fmt.Errorf("%s %v", ctx.Pos, err)
+	}
+	return nil, nil
+}
+
+// addLight adds a point light at a world position and returns its id, rgb are 0-255, flicker is 0-1
+func addLight(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	light := gfx.PointLight{
+		Pos:    [3]float32{float32(arg[0].(float64)), float32(arg[1].(float64)), float32(arg[2].(float64))},
+		Color:  [3]float32{float32(arg[3].(float64) / 255), float32(arg[4].(float64) / 255), float32(arg[5].(float64) / 255)},
+		Radius: float32(arg[6].(float64)),
+	}
+	if len(arg) > 7 {
+		light.Flicker = float32(arg[7].(float64))
+	}
+	app := ctx.App["app"].(*gfx.App)
+	return float64(app.View.AddLight(light)), nil
+}
+
+func moveLight(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	id := int(arg[0].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	return app.View.MoveLight(id, float32(arg[1].(float64)), float32(arg[2].(float64)), float32(arg[3].(float64))), nil
+}
+
+func removeLight(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	id := int(arg[0].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	return app.View.RemoveLight(id), nil
+}
+
+// startEmitter starts particles from config.json at a world position and returns the emitter's id
+func startEmitter(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	name := arg[0].(string)
+	app := ctx.App["app"].(*gfx.App)
+	id, err := app.View.StartEmitter(name, float32(arg[1].(float64)), float32(arg[2].(float64)), float32(arg[3].(float64)))
+	if err != nil {
+		return nil, fmt.Errorf("%s %v", ctx.Pos, err)
+	}
+	return float64(id), nil
+}
+
+// startWeather starts particles over the whole view and returns the emitter's id
+func startWeather(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	name := arg[0].(string)
+	app := ctx.App["app"].(*gfx.App)
+	id, err := app.View.StartWeather(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s %v", ctx.Pos, err)
+	}
+	return float64(id), nil
+}
+
+func moveEmitter(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	id := int(arg[0].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	return app.View.MoveEmitter(id, float32(arg[1].(float64)), float32(arg[2].(float64)), float32(arg[3].(float64))), nil
+}
+
+func stopEmitter(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	id := int(arg[0].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	return app.View.StopEmitter(id), nil
+}
+
+func setViewer(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	x := int(arg[0].(float64))
+	y := int(arg[1].(float64))
+	z := int(arg[2].(float64))
+	radius := int(arg[3].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	app.View.SetViewer(x, y, z, radius)
+	return nil, nil
+}
+
+func clearViewer(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.View.ClearViewer()
+	return nil, nil
+}
+
+func isVisible(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	x := int(arg[0].(float64))
+	y := int(arg[1].(float64))
+	z := int(arg[2].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	return app.View.IsVisible(x, y, z), nil
+}
+
+func setXray(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	x := int(arg[0].(float64))
+	y := int(arg[1].(float64))
+	z := int(arg[2].(float64))
+	app := ctx.App["app"].(*gfx.App)
+	app.View.SetXrayTarget(x, y, z)
+	return nil, nil
+}
+
+func clearXray(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.View.ClearXrayTarget()
+	return nil, nil
+}
+
 func setViewScroll(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	sx := float32(arg[0].(float64))
 	sy := float32(arg[1].(float64))
@@ -180,8 +399,16 @@ func setAnimation(ctx *bscript.Context, arg ...interface{}) (interface{}, error)
 	name := arg[3].(string)
 	dir := arg[4].(float64)
 	animationSpeed := arg[5].(float64)
+	phase := 0
+	if len(arg) > 6 {
+		phase = int(arg[6].(float64))
+	}
+	animation, err := resolveAnimation(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 	app := ctx.App["app"].(*gfx.App)
-	app.View.SetShapeAnimation(x, y, z, shapes.AnimationNames[name], shapes.Direction(dir), animationSpeed)
+	app.View.SetShapeAnimation(x, y, z, animation, shapes.Direction(dir), animationSpeed, phase)
 	return nil, nil
 }
 
@@ -189,20 +416,80 @@ func isEmpty(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	tx := int(arg[0].(float64))
 	ty := int(arg[1].(float64))
 	tz := int(arg[2].(float64))
-	shape := arg[3].(string)
+	name := arg[3].(string)
+	shape, err := resolveShape(ctx, name)
+	if err != nil {
+		return nil, err
+	}
 	app := ctx.App["app"].(*gfx.App)
-	return app.View.IsEmpty(tx, ty, tz, shapes.Shapes[shapes.Names[shape]]), nil
+	return app.View.IsEmpty(tx, ty, tz, shape), nil
 }
 
 func moveViewTo(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	x := int(arg[0].(float64))
 	y := int(arg[1].(float64))
 	app := ctx.App["app"].(*gfx.App)
+	// a jump: the camera starts again from here
+	app.Camera.Release()
 	app.Loader.MoveTo(x, y)
 	app.View.Load()
 	return nil, nil
 }
 
+// cameraFollow eases the view towards a world position, speed defaults to 4
+func cameraFollow(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	speed := 4.0
+	if len(arg) > 3 {
+		speed = arg[3].(float64)
+	}
+	app := ctx.App["app"].(*gfx.App)
+	if err := app.Camera.Follow(arg[0].(float64), arg[1].(float64), arg[2].(float64), speed); err != nil {
+		return nil, fmt.Errorf("%s %v", ctx.Pos, err)
+	}
+	return nil, nil
+}
+
+func cameraPanTo(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.Camera.PanTo(arg[0].(float64), arg[1].(float64), arg[2].(float64), arg[3].(float64))
+	return nil, nil
+}
+
+func cameraJumpTo(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.Camera.JumpTo(arg[0].(float64), arg[1].(float64), arg[2].(float64))
+	return nil, nil
+}
+
+func cameraShake(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.Camera.Shake(arg[0].(float64), arg[1].(float64))
+	return nil, nil
+}
+
+// cameraZoom sets the zoom, over a number of seconds if given
+func cameraZoom(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	seconds := 0.0
+	if len(arg) > 1 {
+		seconds = arg[1].(float64)
+	}
+	app := ctx.App["app"].(*gfx.App)
+	app.Camera.ZoomTo(arg[0].(float64), seconds)
+	return nil, nil
+}
+
+func cameraZoomLimits(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.View.SetZoomLimits(arg[0].(float64), arg[1].(float64))
+	return nil, nil
+}
+
+func cameraRelease(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
+	app := ctx.App["app"].(*gfx.App)
+	app.Camera.Release()
+	return nil, nil
+}
+
 func fadeViewTo(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 	x := int(arg[0].(float64))
 	y := int(arg[1].(float64))
@@ -211,6 +498,7 @@ func fadeViewTo(ctx *bscript.Context, arg ...interface{}) (interface{}, error) {
 		app.FadeIn(func() {
 			app.FadeDone()
 		})
+		app.Camera.Release()
 		app.Loader.MoveTo(x, y)
 		app.View.Load()
 	})
@@ -504,22 +792,47 @@ var constants map[string]interface{} = map[string]interface{}{
 func InitScript() {
 	bscript.AddBuiltin("intersectsShapes", intersectsShapes)
 	bscript.AddBuiltin("setMaxZ", setMaxZ)
+	bscript.AddBuiltin("setCutaway", setCutaway)
+	bscript.AddBuiltin("clearCutaway", clearCutaway)
 	bscript.AddBuiltin("isPressed", isPressed)
 	bscript.AddBuiltin("isDown", isDown)
 	bscript.AddBuiltin("getPosition", getPosition)
 	bscript.AddBuiltin("eraseShape", eraseShape)
 	bscript.AddBuiltin("setShape", setShape)
+	bscript.AddBuiltin("setShapeVariant", setShapeVariant)
 	bscript.AddBuiltin("moveShape", moveShape)
 	bscript.AddBuiltin("getShape", getShape)
+	bscript.AddBuiltin("getShapeInfo", getShapeInfo)
 	bscript.AddBuiltin("setShapeExtra", setShapeExtra)
 	bscript.AddBuiltin("getShapeExtra", getShapeExtra)
 	bscript.AddBuiltin("eraseAllExtras", eraseAllExtras)
 	bscript.AddBuiltin("setAnimation", setAnimation)
 	bscript.AddBuiltin("setOffset", setOffset)
+	bscript.AddBuiltin("setTint", setTint)
+	bscript.AddBuiltin("setPalette", setPalette)
+	bscript.AddBuiltin("addLight", addLight)
+	bscript.AddBuiltin("moveLight", moveLight)
+	bscript.AddBuiltin("removeLight", removeLight)
+	bscript.AddBuiltin("startEmitter", startEmitter)
+	bscript.AddBuiltin("startWeather", startWeather)
+	bscript.AddBuiltin("moveEmitter", moveEmitter)
+	bscript.AddBuiltin("stopEmitter", stopEmitter)
+	bscript.AddBuiltin("setViewer", setViewer)
+	bscript.AddBuiltin("clearViewer", clearViewer)
+	bscript.AddBuiltin("isVisible", isVisible)
+	bscript.AddBuiltin("setXray", setXray)
+	bscript.AddBuiltin("clearXray", clearXray)
 	bscript.AddBuiltin("isEmpty", isEmpty)
 	bscript.AddBuiltin("moveViewTo", moveViewTo)
 	bscript.AddBuiltin("fadeViewTo", fadeViewTo)
 	bscript.AddBuiltin("setViewScroll", setViewScroll)
+	bscript.AddBuiltin("cameraFollow", cameraFollow)
+	bscript.AddBuiltin("cameraPanTo", cameraPanTo)
+	bscript.AddBuiltin("cameraJumpTo", cameraJumpTo)
+	bscript.AddBuiltin("cameraShake", cameraShake)
+	bscript.AddBuiltin("cameraZoom", cameraZoom)
+	bscript.AddBuiltin("cameraZoomLimits", cameraZoomLimits)
+	bscript.AddBuiltin("cameraRelease", cameraRelease)
 	bscript.AddBuiltin("print", print)
 	bscript.AddBuiltin("getDir", getDir)
 	bscript.AddBuiltin("getDelta", getDelta)
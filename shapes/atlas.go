@@ -0,0 +1,244 @@
+package shapes
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// transparent pixels around each image in the atlas, so linear filtering doesn't bleed between sheets
+const atlasPadding = 2
+
+// bump this when the packing changes, to invalidate old caches
+const atlasVersion = 1
+
+const atlasMetaFile = "atlas.json"
+
+type atlasPlacement struct {
+	Page int `json:"page"`
+	X    int `json:"x"`
+	Y    int `json:"y"`
+}
+
+type atlasMeta struct {
+	Key        string           `json:"key"`
+	Pages      []string         `json:"pages"`
+	Placements []atlasPlacement `json:"placements"`
+}
+
+// a page is filled with shelves: rows of images, the first one sets the shelf height
+type atlasPage struct {
+	x, y, shelfH  int
+	width, height int
+}
+
+func (page *atlasPage) place(w, h, maxSize int) (int, int, bool) {
+	x, y, shelfH := page.x, page.y, page.shelfH
+	if x+w > maxSize {
+		x = 0
+		y += shelfH
+		shelfH = 0
+	}
+	if y+h > maxSize {
+		return 0, 0, false
+	}
+	page.x = x + w
+	page.y = y
+	if h > shelfH {
+		shelfH = h
+	}
+	page.shelfH = shelfH
+	if page.x > page.width {
+		page.width = page.x
+	}
+	if y+shelfH > page.height {
+		page.height = y + shelfH
+	}
+	return x, y, true
+}
+
+// PackAtlas combines all loaded Images into a few textures, each at most maxSize pixels square.
+// Every shape's texture coordinates are rewritten to point into these pages, which then replace Images.
+// The pages are cached in cacheDir and reused as long as the source images don't change.
+func PackAtlas(cacheDir string, maxSize int) error {
+	key, err := atlasKey(maxSize)
+	if err != nil {
+		return err
+	}
+	meta, pages := loadAtlas(cacheDir, key)
+	if meta == nil {
+		fmt.Printf("Packing %d images into an atlas...\n", len(Images))
+		meta, pages = packAtlas(key, maxSize)
+		if err := saveAtlas(cacheDir, meta, pages); err != nil {
+			fmt.Printf("Can't cache the atlas: %v\n", err)
+		}
+	}
+	applyAtlas(meta, pages)
+	fmt.Printf("Using %d atlas pages for %d images.\n", len(pages), len(meta.Placements))
+	return nil
+}
+
+func atlasKey(maxSize int) (string, error) {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d %d %d\n", atlasVersion, atlasPadding, maxSize)
+	for _, sources := range ImageSources {
+		for _, path := range sources {
+			info, err := os.Stat(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func packAtlas(key string, maxSize int) (*atlasMeta, []*image.RGBA) {
+	// tallest first, so the shelves waste less space
+	order := make([]int, len(Images))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return Images[order[a]].Bounds().Dy() > Images[order[b]].Bounds().Dy()
+	})
+
+	layout := []*atlasPage{}
+	placements := make([]atlasPlacement, len(Images))
+	for _, imageIndex := range order {
+		bounds := Images[imageIndex].Bounds()
+		w := bounds.Dx() + atlasPadding*2
+		h := bounds.Dy() + atlasPadding*2
+		placed := false
+		if w <= maxSize && h <= maxSize {
+			for pageIndex, page := range layout {
+				if x, y, ok := page.place(w, h, maxSize); ok {
+					placements[imageIndex] = atlasPlacement{pageIndex, x + atlasPadding, y + atlasPadding}
+					placed = true
+					break
+				}
+			}
+		}
+		if !placed {
+			// oversized images get a page of their own
+			page := &atlasPage{}
+			size := maxSize
+			if w > size {
+				size = w
+			}
+			if h > size {
+				size = h
+			}
+			x, y, _ := page.place(w, h, size)
+			placements[imageIndex] = atlasPlacement{len(layout), x + atlasPadding, y + atlasPadding}
+			layout = append(layout, page)
+		}
+	}
+
+	pages := make([]*image.RGBA, len(layout))
+	for i, page := range layout {
+		pages[i] = image.NewRGBA(image.Rect(0, 0, page.width, page.height))
+	}
+	for imageIndex, img := range Images {
+		p := placements[imageIndex]
+		bounds := img.Bounds()
+		draw.Draw(pages[p.Page], image.Rect(p.X, p.Y, p.X+bounds.Dx(), p.Y+bounds.Dy()), img, bounds.Min, draw.Src)
+	}
+
+	meta := &atlasMeta{
+		Key:        key,
+		Placements: placements,
+	}
+	for i := range pages {
+		meta.Pages = append(meta.Pages, fmt.Sprintf("atlas%d.png", i))
+	}
+	return meta, pages
+}
+
+func loadAtlas(cacheDir, key string) (*atlasMeta, []*image.RGBA) {
+	bytes, err := ioutil.ReadFile(filepath.Join(cacheDir, atlasMetaFile))
+	if err != nil {
+		return nil, nil
+	}
+	meta := &atlasMeta{}
+	if err := json.Unmarshal(bytes, meta); err != nil || meta.Key != key || len(meta.Placements) != len(Images) {
+		return nil, nil
+	}
+	pages := []*image.RGBA{}
+	for _, name := range meta.Pages {
+		img, err := loadImage(filepath.Join(cacheDir, name))
+		if err != nil {
+			return nil, nil
+		}
+		rgba := image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, image.Point{0, 0}, draw.Src)
+		pages = append(pages, rgba)
+	}
+	return meta, pages
+}
+
+func saveAtlas(cacheDir string, meta *atlasMeta, pages []*image.RGBA) error {
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		return err
+	}
+	for i, page := range pages {
+		f, err := os.Create(filepath.Join(cacheDir, meta.Pages[i]))
+		if err != nil {
+			return err
+		}
+		err = png.Encode(f, page)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	bytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	// written last: only a complete cache has a meta file
+	return ioutil.WriteFile(filepath.Join(cacheDir, atlasMetaFile), bytes, 0644)
+}
+
+func applyAtlas(meta *atlasMeta, pages []*image.RGBA) {
+	// creatures share a TextureCoords between Tex and their first frame, only move each once
+	seen := map[*TextureCoords]bool{}
+	remap := func(tc *TextureCoords, p atlasPlacement) {
+		if tc == nil || seen[tc] {
+			return
+		}
+		seen[tc] = true
+		bounds := pages[p.Page].Bounds()
+		tc.PixelOffset[0] += float32(p.X)
+		tc.PixelOffset[1] += float32(p.Y)
+		*tc = *NewTextureCoords(bounds, tc.PixelOffset[0], tc.PixelOffset[1], tc.PixelDim[0], tc.PixelDim[1])
+	}
+
+	for _, shape := range Shapes {
+		if shape == nil {
+			continue
+		}
+		p := meta.Placements[shape.ImageIndex]
+		remap(shape.Tex, p)
+		for _, animation := range shape.Animations {
+			for _, steps := range animation.Tex {
+				for _, tc := range steps {
+					remap(tc, p)
+				}
+			}
+		}
+		shape.ImageIndex = p.Page
+	}
+
+	Images = make([]image.Image, len(pages))
+	for i, page := range pages {
+		Images[i] = page
+	}
+}
@@ -0,0 +1,77 @@
+package shapes
+
+import (
+	"image"
+	"testing"
+)
+
+func TestAtlasPagePlace(t *testing.T) {
+	type placement struct {
+		x, y int
+		ok   bool
+	}
+	tests := []struct {
+		name     string
+		sizes    [][2]int
+		expected []placement
+		width    int
+		height   int
+	}{
+		{"one shelf", [][2]int{{4, 4}, {4, 2}}, []placement{{0, 0, true}, {4, 0, true}}, 8, 4},
+		{"next shelf", [][2]int{{6, 4}, {6, 2}}, []placement{{0, 0, true}, {0, 4, true}}, 6, 6},
+		{"page full", [][2]int{{10, 6}, {10, 6}}, []placement{{0, 0, true}, {0, 0, false}}, 10, 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := &atlasPage{}
+			for i, size := range test.sizes {
+				x, y, ok := page.place(size[0], size[1], 10)
+				if got := (placement{x, y, ok}); got != test.expected[i] {
+					t.Errorf("image %d placed at %v, expected %v", i, got, test.expected[i])
+				}
+			}
+			if page.width != test.width || page.height != test.height {
+				t.Errorf("page is %dx%d, expected %dx%d", page.width, page.height, test.width, test.height)
+			}
+		})
+	}
+}
+
+func TestPackAtlas(t *testing.T) {
+	pad := atlasPadding * 2
+	tests := []struct {
+		name    string
+		sizes   [][2]int
+		maxSize int
+		pages   int
+	}{
+		{"one page", [][2]int{{8, 8}, {8, 4}, {4, 8}}, 64, 1},
+		{"two pages", [][2]int{{20, 20}, {20, 20}}, 20 + pad, 2},
+		{"oversized", [][2]int{{100, 100}, {8, 8}}, 64, 2},
+	}
+	defer func(images []image.Image) { Images = images }(Images)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			Images = []image.Image{}
+			for _, size := range test.sizes {
+				Images = append(Images, image.NewRGBA(image.Rect(0, 0, size[0], size[1])))
+			}
+			meta, pages := packAtlas("key", test.maxSize)
+			if len(pages) != test.pages {
+				t.Fatalf("got %d pages, expected %d", len(pages), test.pages)
+			}
+			for i, p := range meta.Placements {
+				bounds := image.Rect(p.X, p.Y, p.X+test.sizes[i][0], p.Y+test.sizes[i][1])
+				if !bounds.In(pages[p.Page].Bounds()) {
+					t.Errorf("image %d at %v is outside page %d %v", i, bounds, p.Page, pages[p.Page].Bounds())
+				}
+				for j, q := range meta.Placements[:i] {
+					other := image.Rect(q.X, q.Y, q.X+test.sizes[j][0], q.Y+test.sizes[j][1]).Inset(-atlasPadding)
+					if p.Page == q.Page && bounds.Overlaps(other) {
+						t.Errorf("image %d at %v overlaps image %d at %v", i, bounds, j, other)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package shapes
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/uzudil/isongn/util"
+)
+
+// SheetConfig is one sprite sheet image and the shapes cut out of it.
+type SheetConfig struct {
+	// shapes are numbered id*256 + their position in the sheet and maps save these numbers, so the id
+	// must never change. Defaults to the sheet's position in the main config file, included sheets must set it.
+	Id    *int   `json:"id"`
+	Image string `json:"image"`
+	// the dir Image is in: the included file's dir, or the images dir for the main config file
+	Dir    string        `json:"-"`
+	Dpi    float64       `json:"dpi"`
+	Grid   GridConfig    `json:"grid"`
+	Shapes []ShapeConfig `json:"shapes"`
+	Source
+}
+
+// Source is where a definition was read from, for error messages. Path is a json path from the root of File, like $.shapes[0].
+type Source struct {
+	File string `json:"-"`
+	Path string `json:"-"`
+}
+
+// IncludeConfig lists glob patterns, relative to the game dir, of files holding more definitions.
+// A file holds one definition or an array of them.
+type IncludeConfig struct {
+	Shapes    []string `json:"shapes"`
+	Creatures []string `json:"creatures"`
+}
+
+// the highest sheet or creature id
+const MAX_SHEET_ID = 0xff
+
+type GridConfig struct {
+	Units []float64 `json:"units"`
+}
+
+// ShapeFlags are the options shared by shapes and creatures.
+type ShapeFlags struct {
+	Sway      bool `json:"sway"`
+	Bob       bool `json:"bob"`
+	Breathe   bool `json:"breathe"`
+	NoSupport bool `json:"nosupport"`
+	Extra     bool `json:"extra"`
+	// drawn at full brightness, whatever the light
+	Emissive bool `json:"emissive"`
+	// a light carried by the shape
+	Light *LightConfig `json:"light"`
+}
+
+// LightConfig is a point light around a shape.
+type LightConfig struct {
+	// red, green, blue 0-255, defaults to white
+	Color []float64 `json:"color"`
+	// in tiles
+	Radius float64 `json:"radius"`
+	// 0 for a steady light, up to 1
+	Flicker float64 `json:"flicker"`
+	// from the shape's origin, defaults to the middle of its top
+	Offset []float64 `json:"offset"`
+}
+
+type ShapeConfig struct {
+	ShapeFlags
+	Name     string    `json:"name"`
+	Pos      []float64 `json:"pos"`
+	Size     []float64 `json:"size"`
+	Fudge    float64   `json:"fudge"`
+	AlphaMin *float64  `json:"alphaMin"`
+	Offset   []float64 `json:"offset"`
+	Group    int       `json:"group"`
+	Ref      string    `json:"ref"`
+	Target   string    `json:"target"`
+	// free-form values for the game, see PROP_WALK_COST and PROP_BLOCKS_SIGHT for the ones the engine uses
+	Props map[string]interface{} `json:"props"`
+	// the shapes to pick from when this one is placed, the list can include the shape itself
+	Variants []VariantConfig `json:"variants"`
+	// the cells which block movement, when not all of them do: a list of x,y,z cells...
+	Solid [][]float64 `json:"solid"`
+	// ...or per z layer, one string per y row with a '#' for each solid x
+	SolidLayers [][]string `json:"solidLayers"`
+	// the name of a generated copy, flipped horizontally with its x and y sizes swapped
+	Mirror string `json:"mirror"`
+	// the facings the editor's rotate key cycles through, in order, like wall.n, wall.e, wall.s, wall.w.
+	// They can be mirrors. Without it the key switches between a shape and its mirror.
+	Rotations []string `json:"rotations"`
+}
+
+type VariantConfig struct {
+	Name string `json:"name"`
+	// relative chance of being picked, defaults to 1
+	Weight *float64 `json:"weight"`
+}
+
+type CreatureConfig struct {
+	ShapeFlags
+	// a creature is numbered id*256 like the first shape of a sheet, so it shares the ids of the sheets.
+	// Defaults to the number of sheets plus its position in the main config file, included creatures must set it.
+	Id     *int          `json:"id"`
+	Name   string        `json:"name"`
+	Size   []float64     `json:"size"`
+	Dim    []float64     `json:"dim"`
+	Frames []FrameConfig `json:"frames"`
+	// or, instead of dim and frames: an Aseprite or TexturePacker json export, relative to the creatures dir
+	Sheet string `json:"sheet"`
+	// sheet tag (or frame name) prefix to animation name, when they differ
+	Tags map[string]string `json:"tags"`
+	// the directions used by sheet tags which don't name one, defaults to all
+	Dirs []string `json:"dirs"`
+	// animation name to play mode, for sheet animations
+	Modes map[string]string      `json:"modes"`
+	Props map[string]interface{} `json:"props"`
+	Source
+}
+
+type FrameConfig struct {
+	Name  string   `json:"name"`
+	Steps int      `json:"steps"`
+	Dirs  []string `json:"dirs"`
+	// loop (the default), once, pingpong or hold
+	Mode string `json:"mode"`
+	// milliseconds per step: one value for all steps, or one per step
+	Durations []float64 `json:"durations"`
+}
+
+// ConfigError points at the offending value in a config file.
+type ConfigError struct {
+	File  string
+	Path  string
+	Shape string
+	Msg   string
+}
+
+func (e *ConfigError) Error() string {
+	s := e.File + ": " + e.Path
+	if e.Shape != "" {
+		s += fmt.Sprintf(" (shape %s)", e.Shape)
+	}
+	return s + ": " + e.Msg
+}
+
+// ConfigErrors collects every problem found in a config so they can be fixed in one go.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return "invalid config:\n\t" + strings.Join(lines, "\n\t")
+}
+
+func (errs *ConfigErrors) Add(file, path, shape, format string, args ...interface{}) {
+	*errs = append(*errs, &ConfigError{File: file, Path: path, Shape: shape, Msg: fmt.Sprintf(format, args...)})
+}
+
+type validator struct {
+	errors ConfigErrors
+}
+
+func (v *validator) fail(src *Source, path, shape, format string, args ...interface{}) {
+	v.errors.Add(src.File, src.Path+path, shape, format, args...)
+}
+
+func (v *validator) length(src *Source, path, shape string, values []float64, n int, required bool) {
+	if len(values) == 0 && required {
+		v.fail(src, path, shape, "missing required key")
+	} else if len(values) != 0 && len(values) != n {
+		v.fail(src, path, shape, "expected %d values, got %d", n, len(values))
+	}
+}
+
+// props checks the values of the keys the engine knows about
+func (v *validator) props(src *Source, path, shape string, props map[string]interface{}) {
+	if value, ok := props[PROP_WALK_COST]; ok {
+		if n, ok := value.(float64); !ok || n < 1 || n != math.Trunc(n) {
+			v.fail(src, path+"."+PROP_WALK_COST, shape, "must be a whole number of at least 1")
+		}
+	}
+	if value, ok := props[PROP_BLOCKS_SIGHT]; ok {
+		if _, ok := value.(bool); !ok {
+			v.fail(src, path+"."+PROP_BLOCKS_SIGHT, shape, "must be true or false")
+		}
+	}
+}
+
+func (v *validator) mask(src *Source, path string, s *ShapeConfig) {
+	if len(s.Size) != 3 {
+		return
+	}
+	w, h, d := int(s.Size[0]), int(s.Size[1]), int(math.Max(s.Size[2], 1))
+	if len(s.Solid) > 0 && len(s.SolidLayers) > 0 {
+		v.fail(src, path+".solid", s.Name, "use either solid or solidLayers")
+	}
+	for i, cell := range s.Solid {
+		if len(cell) != 3 {
+			v.fail(src, fmt.Sprintf("%s.solid[%d]", path, i), s.Name, "expected 3 values, got %d", len(cell))
+		} else if cell[0] < 0 || cell[1] < 0 || cell[2] < 0 || int(cell[0]) >= w || int(cell[1]) >= h || int(cell[2]) >= d {
+			v.fail(src, fmt.Sprintf("%s.solid[%d]", path, i), s.Name, "cell is outside the shape's size")
+		}
+	}
+	if len(s.SolidLayers) > d {
+		v.fail(src, path+".solidLayers", s.Name, "expected at most %d layers, got %d", d, len(s.SolidLayers))
+	}
+	for z, layer := range s.SolidLayers {
+		if len(layer) > h {
+			v.fail(src, fmt.Sprintf("%s.solidLayers[%d]", path, z), s.Name, "expected at most %d rows, got %d", h, len(layer))
+		}
+		for y, row := range layer {
+			if len(row) > w || strings.Trim(row, "#.") != "" {
+				v.fail(src, fmt.Sprintf("%s.solidLayers[%d][%d]", path, z, y), s.Name, "expected at most %d of '#' or '.'", w)
+			}
+		}
+	}
+}
+
+// Validate checks the shape and creature definitions before anything is loaded.
+// All problems are returned at once, each naming the file, the json path and the shape.
+func Validate(sheets []SheetConfig, creatures []CreatureConfig) ConfigErrors {
+	v := &validator{}
+	names := map[string]string{}
+	ids := map[int]string{}
+	checkId := func(src *Source, id *int) {
+		if id == nil {
+			v.fail(src, ".id", "", "missing required key")
+		} else if *id < 0 || *id > MAX_SHEET_ID {
+			v.fail(src, ".id", "", "must be between 0 and %d", MAX_SHEET_ID)
+		} else if other, ok := ids[*id]; ok {
+			v.fail(src, ".id", "", "duplicate id %d, already used in %s", *id, other)
+		} else {
+			ids[*id] = fmt.Sprintf("%s: %s", src.File, src.Path)
+		}
+	}
+	define := func(src *Source, path, name string) {
+		if !strings.HasSuffix(path, ".mirror") {
+			path += ".name"
+		}
+		if name == "" {
+			v.fail(src, path, "", "missing required key")
+			return
+		}
+		where := fmt.Sprintf("%s: %s%s", src.File, src.Path, path)
+		if other, ok := names[name]; ok {
+			v.fail(src, path, name, "duplicate name, already defined in %s", other)
+			return
+		}
+		names[name] = where
+	}
+
+	for i := range sheets {
+		sheet := &sheets[i]
+		checkId(&sheet.Source, sheet.Id)
+		if sheet.Image == "" {
+			v.fail(&sheet.Source, ".image", "", "missing required key")
+		}
+		if sheet.Dpi <= 0 {
+			v.fail(&sheet.Source, ".dpi", "", "must be a positive number")
+		}
+		v.length(&sheet.Source, ".grid.units", "", sheet.Grid.Units, 2, true)
+		for j := range sheet.Shapes {
+			define(&sheet.Source, fmt.Sprintf(".shapes[%d]", j), sheet.Shapes[j].Name)
+			if sheet.Shapes[j].Mirror != "" {
+				define(&sheet.Source, fmt.Sprintf(".shapes[%d].mirror", j), sheet.Shapes[j].Mirror)
+			}
+		}
+		if len(sheet.Shapes) > 0x100 {
+			v.fail(&sheet.Source, ".shapes", "", "an image can hold at most %d shapes, found %d", 0x100, len(sheet.Shapes))
+		}
+	}
+	for i := range creatures {
+		checkId(&creatures[i].Source, creatures[i].Id)
+		define(&creatures[i].Source, "", creatures[i].Name)
+	}
+
+	// edges and variants can only use shapes cut from the sheets, not creatures
+	sizes := map[string][]float64{}
+	for i := range sheets {
+		for _, s := range sheets[i].Shapes {
+			sizes[s.Name] = s.Size
+			if s.Mirror != "" && len(s.Size) == 3 {
+				sizes[s.Mirror] = []float64{s.Size[1], s.Size[0], s.Size[2]}
+			}
+		}
+	}
+	rotations := map[string]string{}
+	for i := range sheets {
+		sheet := &sheets[i]
+		for j, s := range sheet.Shapes {
+			path := fmt.Sprintf(".shapes[%d]", j)
+			v.length(&sheet.Source, path+".pos", s.Name, s.Pos, 2, true)
+			v.length(&sheet.Source, path+".size", s.Name, s.Size, 3, true)
+			v.length(&sheet.Source, path+".offset", s.Name, s.Offset, 3, false)
+			if s.Ref != "" {
+				if _, ok := sizes[s.Ref]; !ok {
+					v.fail(&sheet.Source, path+".ref", s.Name, "unknown shape '%s'", s.Ref)
+				}
+				if _, ok := sizes[s.Target]; !ok && s.Target != "" && s.Target != "default" {
+					v.fail(&sheet.Source, path+".target", s.Name, "unknown shape '%s'", s.Target)
+				}
+				if len(strings.Split(s.Name, ".")) < 3 {
+					v.fail(&sheet.Source, path+".name", s.Name, "edge names must look like <kind>.<name>.<edge>")
+				}
+			} else if s.Target != "" {
+				v.fail(&sheet.Source, path+".target", s.Name, "target needs a ref")
+			}
+			v.props(&sheet.Source, path+".props", s.Name, s.Props)
+			v.light(&sheet.Source, path+".light", s.Name, s.Light)
+			v.mask(&sheet.Source, path, &s)
+			for k, variant := range s.Variants {
+				variantPath := fmt.Sprintf("%s.variants[%d]", path, k)
+				if size, ok := sizes[variant.Name]; !ok {
+					v.fail(&sheet.Source, variantPath+".name", s.Name, "unknown shape '%s'", variant.Name)
+				} else if fmt.Sprint(size) != fmt.Sprint(s.Size) {
+					v.fail(&sheet.Source, variantPath+".name", s.Name, "variant %s must be the same size", variant.Name)
+				}
+				if variant.Weight != nil && *variant.Weight <= 0 {
+					v.fail(&sheet.Source, variantPath+".weight", s.Name, "must be a positive number")
+				}
+			}
+			for k, name := range s.Rotations {
+				rotationPath := fmt.Sprintf("%s.rotations[%d]", path, k)
+				if _, ok := sizes[name]; !ok {
+					v.fail(&sheet.Source, rotationPath, s.Name, "unknown shape '%s'", name)
+				} else if other, ok := rotations[name]; ok {
+					v.fail(&sheet.Source, rotationPath, s.Name, "%s already rotates in %s", name, other)
+				} else {
+					rotations[name] = fmt.Sprintf("%s: %s%s", sheet.File, sheet.Path, path)
+				}
+			}
+		}
+	}
+
+	for i := range creatures {
+		c := &creatures[i]
+		v.length(&c.Source, ".size", c.Name, c.Size, 3, true)
+		v.props(&c.Source, ".props", c.Name, c.Props)
+		v.light(&c.Source, ".light", c.Name, c.Light)
+		if c.Sheet != "" {
+			if len(c.Frames) > 0 || len(c.Dim) > 0 {
+				v.fail(&c.Source, ".sheet", c.Name, "use either sheet or dim and frames")
+			}
+		} else {
+			v.length(&c.Source, ".dim", c.Name, c.Dim, 2, true)
+			if len(c.Frames) == 0 {
+				v.fail(&c.Source, ".frames", c.Name, "missing required key")
+			}
+			if len(c.Tags) > 0 || len(c.Dirs) > 0 || len(c.Modes) > 0 {
+				v.fail(&c.Source, ".sheet", c.Name, "tags, dirs and modes need a sheet")
+			}
+		}
+		for name, mode := range c.Modes {
+			if _, ok := AnimationModes[mode]; !ok {
+				v.fail(&c.Source, ".modes."+name, c.Name, "unknown mode '%s'", mode)
+			}
+		}
+		for k, dir := range c.Dirs {
+			if _, ok := Directions[dir]; !ok || dir == "" {
+				v.fail(&c.Source, fmt.Sprintf(".dirs[%d]", k), c.Name, "unknown direction '%s'", dir)
+			}
+		}
+		for j, frame := range c.Frames {
+			framePath := fmt.Sprintf(".frames[%d]", j)
+			if frame.Name == "" {
+				v.fail(&c.Source, framePath+".name", c.Name, "missing required key")
+			}
+			if frame.Steps <= 0 {
+				v.fail(&c.Source, framePath+".steps", c.Name, "must be a positive number")
+			}
+			if _, ok := AnimationModes[frame.Mode]; !ok {
+				v.fail(&c.Source, framePath+".mode", c.Name, "unknown mode '%s'", frame.Mode)
+			}
+			if len(frame.Durations) > 1 && len(frame.Durations) != frame.Steps {
+				v.fail(&c.Source, framePath+".durations", c.Name, "expected 1 or %d values, got %d", frame.Steps, len(frame.Durations))
+			}
+			for k, d := range frame.Durations {
+				if d <= 0 {
+					v.fail(&c.Source, fmt.Sprintf("%s.durations[%d]", framePath, k), c.Name, "must be a positive number")
+				}
+			}
+			if len(frame.Dirs) == 0 {
+				v.fail(&c.Source, framePath+".dirs", c.Name, "missing required key")
+			}
+			for k, dir := range frame.Dirs {
+				if _, ok := Directions[dir]; !ok {
+					v.fail(&c.Source, fmt.Sprintf("%s.dirs[%d]", framePath, k), c.Name, "unknown direction '%s'", dir)
+				}
+			}
+		}
+	}
+	return v.errors
+}
+
+// SetSource records where each inline definition of the main config file came from.
+// Their images are in the game's images dir. A sheet's id defaults to its position and a creature's
+// to the number of sheets plus its position, which is how they were numbered before ids.
+func SetSource(file string, sheets []SheetConfig, creatures []CreatureConfig) {
+	for i := range sheets {
+		sheets[i].Source = Source{file, fmt.Sprintf("$.shapes[%d]", i)}
+		sheets[i].Dir = filepath.Join(filepath.Dir(file), "images")
+		if sheets[i].Id == nil {
+			id := i
+			sheets[i].Id = &id
+		}
+	}
+	for i := range creatures {
+		creatures[i].Source = Source{file, fmt.Sprintf("$.creatures[%d]", i)}
+		if creatures[i].Id == nil {
+			id := len(sheets) + i
+			creatures[i].Id = &id
+		}
+	}
+}
+
+// ReadIncludes loads the definition files matching the include patterns, in pattern then file name order.
+func ReadIncludes(gameDir string, include IncludeConfig) ([]SheetConfig, []CreatureConfig, error) {
+	sheets := []SheetConfig{}
+	creatures := []CreatureConfig{}
+	err := readIncludes(gameDir, include.Shapes, func(file string, data []byte, many bool) error {
+		if many {
+			defs := []SheetConfig{}
+			if err := util.DecodeJSON(file, data, &defs); err != nil {
+				return err
+			}
+			for i := range defs {
+				defs[i].Source = Source{file, fmt.Sprintf("$[%d]", i)}
+				defs[i].Dir = filepath.Dir(file)
+			}
+			sheets = append(sheets, defs...)
+		} else {
+			def := SheetConfig{}
+			if err := util.DecodeJSON(file, data, &def); err != nil {
+				return err
+			}
+			def.Source = Source{file, "$"}
+			def.Dir = filepath.Dir(file)
+			sheets = append(sheets, def)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	err = readIncludes(gameDir, include.Creatures, func(file string, data []byte, many bool) error {
+		if many {
+			defs := []CreatureConfig{}
+			if err := util.DecodeJSON(file, data, &defs); err != nil {
+				return err
+			}
+			for i := range defs {
+				defs[i].Source = Source{file, fmt.Sprintf("$[%d]", i)}
+			}
+			creatures = append(creatures, defs...)
+		} else {
+			def := CreatureConfig{}
+			if err := util.DecodeJSON(file, data, &def); err != nil {
+				return err
+			}
+			def.Source = Source{file, "$"}
+			creatures = append(creatures, def)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sheets, creatures, nil
+}
+
+func readIncludes(gameDir string, patterns []string, decode func(file string, data []byte, many bool) error) error {
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(gameDir, pattern))
+		if err != nil {
+			return fmt.Errorf("bad include pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			fmt.Printf("WARN: include pattern %s matches no files\n", pattern)
+		}
+		for _, file := range matches {
+			// overlapping patterns would define everything twice
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			trimmed := bytes.TrimSpace(data)
+			if err := decode(file, data, len(trimmed) > 0 && trimmed[0] == '['); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package shapes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func floatPtr(n float64) *float64 {
+	return &n
+}
+
+func testSheet(id int, shapes ...ShapeConfig) SheetConfig {
+	return SheetConfig{
+		Id:     intPtr(id),
+		Image:  "sheet.png",
+		Dpi:    1,
+		Grid:   GridConfig{Units: []float64{1, 1}},
+		Shapes: shapes,
+		Source: Source{"shapes.json", "$.shapes[0]"},
+	}
+}
+
+func testShape(name string, size ...float64) ShapeConfig {
+	return ShapeConfig{Name: name, Pos: []float64{0, 0}, Size: size}
+}
+
+func testCreature(id int, name string) CreatureConfig {
+	return CreatureConfig{
+		Id:     intPtr(id),
+		Name:   name,
+		Size:   []float64{1, 1, 2},
+		Dim:    []float64{1, 1},
+		Frames: []FrameConfig{{Name: "walk", Steps: 1, Dirs: []string{"n"}}},
+		Source: Source{"creatures/man.json", "$"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		sheets    []SheetConfig
+		creatures []CreatureConfig
+		// the paths of the expected errors, in order
+		errors []string
+	}{
+		{
+			name:      "valid",
+			sheets:    []SheetConfig{testSheet(0, testShape("floor", 1, 1, 0)), testSheet(1, testShape("wall", 1, 1, 2))},
+			creatures: []CreatureConfig{testCreature(9, "man")},
+		},
+		{
+			name: "missing id",
+			sheets: []SheetConfig{func() SheetConfig {
+				s := testSheet(0, testShape("floor", 1, 1, 0))
+				s.Id = nil
+				return s
+			}()},
+			errors: []string{"$.shapes[0].id"},
+		},
+		{
+			name:   "id out of range",
+			sheets: []SheetConfig{testSheet(MAX_SHEET_ID+1, testShape("floor", 1, 1, 0))},
+			errors: []string{"$.shapes[0].id"},
+		},
+		{
+			name:   "duplicate id",
+			sheets: []SheetConfig{testSheet(3, testShape("floor", 1, 1, 0)), testSheet(3, testShape("wall", 1, 1, 2))},
+			errors: []string{"$.shapes[0].id"},
+		},
+		{
+			name:      "creature id used by a sheet",
+			sheets:    []SheetConfig{testSheet(9, testShape("floor", 1, 1, 0))},
+			creatures: []CreatureConfig{testCreature(9, "man")},
+			errors:    []string{"$.id"},
+		},
+		{
+			name: "missing creature id",
+			creatures: []CreatureConfig{func() CreatureConfig {
+				c := testCreature(9, "man")
+				c.Id = nil
+				return c
+			}()},
+			errors: []string{"$.id"},
+		},
+		{
+			name:   "duplicate name",
+			sheets: []SheetConfig{testSheet(0, testShape("floor", 1, 1, 0), testShape("floor", 1, 1, 0))},
+			errors: []string{"$.shapes[0].shapes[1].name"},
+		},
+		{
+			name:      "shape named like a creature",
+			sheets:    []SheetConfig{testSheet(0, testShape("man", 1, 1, 0))},
+			creatures: []CreatureConfig{testCreature(9, "man")},
+			errors:    []string{"$.name"},
+		},
+		{
+			name: "edge of a creature",
+			sheets: []SheetConfig{testSheet(0,
+				testShape("floor", 1, 1, 0),
+				ShapeConfig{Name: "edge.floor.n", Pos: []float64{1, 0}, Size: []float64{1, 1, 0}, Ref: "man", Target: "man"},
+			)},
+			creatures: []CreatureConfig{testCreature(9, "man")},
+			errors:    []string{"$.shapes[0].shapes[1].ref", "$.shapes[0].shapes[1].target"},
+		},
+		{
+			name: "edge of a mirror",
+			sheets: []SheetConfig{testSheet(0,
+				ShapeConfig{Name: "wall.n", Pos: []float64{0, 0}, Size: []float64{2, 1, 2}, Mirror: "wall.w"},
+				ShapeConfig{Name: "edge.wall.n", Pos: []float64{1, 0}, Size: []float64{1, 1, 0}, Ref: "wall.w", Target: "default"},
+			)},
+		},
+		{
+			name: "mirror size is swapped",
+			sheets: []SheetConfig{testSheet(0,
+				ShapeConfig{Name: "wall.n", Pos: []float64{0, 0}, Size: []float64{2, 1, 2}, Mirror: "wall.w"},
+				ShapeConfig{Name: "walls", Pos: []float64{1, 0}, Size: []float64{1, 2, 2}, Variants: []VariantConfig{{Name: "wall.w"}}},
+				ShapeConfig{Name: "bad.walls", Pos: []float64{2, 0}, Size: []float64{2, 1, 2}, Variants: []VariantConfig{{Name: "wall.w"}}},
+			)},
+			errors: []string{"$.shapes[0].shapes[2].variants[0].name"},
+		},
+		{
+			name: "variant weights",
+			sheets: []SheetConfig{testSheet(0,
+				testShape("grass", 1, 1, 0),
+				ShapeConfig{Name: "grasses", Pos: []float64{1, 0}, Size: []float64{1, 1, 0}, Variants: []VariantConfig{
+					{Name: "grass"}, {Name: "grass", Weight: floatPtr(2)}, {Name: "grass", Weight: floatPtr(0)}, {Name: "grass", Weight: floatPtr(-1)},
+				}},
+			)},
+			errors: []string{"$.shapes[0].shapes[1].variants[2].weight", "$.shapes[0].shapes[1].variants[3].weight"},
+		},
+		{
+			name: "rotations",
+			sheets: []SheetConfig{testSheet(0,
+				ShapeConfig{Name: "wall.n", Pos: []float64{0, 0}, Size: []float64{2, 1, 2}, Mirror: "wall.w", Rotations: []string{"wall.n", "wall.w"}},
+				ShapeConfig{Name: "door", Pos: []float64{1, 0}, Size: []float64{2, 1, 2}, Rotations: []string{"door", "wall.n", "man"}},
+			)},
+			creatures: []CreatureConfig{testCreature(9, "man")},
+			errors:    []string{"$.shapes[0].shapes[1].rotations[1]", "$.shapes[0].shapes[1].rotations[2]"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := []string{}
+			for _, e := range Validate(test.sheets, test.creatures) {
+				paths = append(paths, e.Path)
+			}
+			if test.errors == nil {
+				test.errors = []string{}
+			}
+			if !reflect.DeepEqual(paths, test.errors) {
+				t.Errorf("got errors at %v, expected %v", paths, test.errors)
+			}
+		})
+	}
+}
+
+func TestSetSourceIds(t *testing.T) {
+	sheets := []SheetConfig{{}, {Id: intPtr(5)}, {}}
+	creatures := []CreatureConfig{{}, {Id: intPtr(7)}, {}}
+	SetSource("config.json", sheets, creatures)
+	ids := []int{}
+	for _, sheet := range sheets {
+		ids = append(ids, *sheet.Id)
+	}
+	for _, creature := range creatures {
+		ids = append(ids, *creature.Id)
+	}
+	// the defaults are the image numbers the shapes had before ids
+	if expected := []int{0, 5, 2, 3, 7, 5}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("got ids %v, expected %v", ids, expected)
+	}
+}
+
+func TestSourcePaths(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"one.json":  `{"id": 1, "dpi": 1, "grid": {"units": [1, 1]}}`,
+		"many.json": `[{"id": 2, "dpi": 1, "grid": {"units": [1, 1]}}]`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sheets := []SheetConfig{{Dpi: 1, Grid: GridConfig{Units: []float64{1, 1}}}}
+	SetSource(filepath.Join(dir, "config.json"), sheets, nil)
+	included, _, err := ReadIncludes(dir, IncludeConfig{Shapes: []string{"one.json", "many.json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file, path string
+	}{
+		{"config.json", "$.shapes[0].image"},
+		{"one.json", "$.image"},
+		{"many.json", "$[0].image"},
+	}
+	errs := Validate(append(sheets, included...), nil)
+	if len(errs) != len(tests) {
+		t.Fatalf("got %d errors, expected %d: %v", len(errs), len(tests), errs)
+	}
+	for i, test := range tests {
+		if filepath.Base(errs[i].File) != test.file || errs[i].Path != test.path {
+			t.Errorf("got an error at %s %s, expected %s %s", filepath.Base(errs[i].File), errs[i].Path, test.file, test.path)
+		}
+	}
+}
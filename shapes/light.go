@@ -0,0 +1,49 @@
+package shapes
+
+// Light is a point light which goes wherever its shape is placed.
+type Light struct {
+	// 0-1
+	Color  [3]float32
+	Radius float32
+	// 0 is a steady light, 1 flickers the most
+	Flicker float32
+	// from the shape's origin
+	Offset [3]float32
+}
+
+func newLight(shape *Shape, c *LightConfig) *Light {
+	light := &Light{
+		Color:   [3]float32{1, 1, 1},
+		Radius:  float32(c.Radius),
+		Flicker: float32(c.Flicker),
+		// the middle of the top of the shape
+		Offset: [3]float32{shape.Size[0] / 2, shape.Size[1] / 2, shape.Size[2]},
+	}
+	if len(c.Color) == 3 {
+		light.Color = [3]float32{float32(c.Color[0] / 255), float32(c.Color[1] / 255), float32(c.Color[2] / 255)}
+	}
+	if len(c.Offset) == 3 {
+		light.Offset = [3]float32{float32(c.Offset[0]), float32(c.Offset[1]), float32(c.Offset[2])}
+	}
+	return light
+}
+
+func (v *validator) light(src *Source, path, shape string, c *LightConfig) {
+	if c == nil {
+		return
+	}
+	v.length(src, path+".color", shape, c.Color, 3, false)
+	for _, value := range c.Color {
+		if value < 0 || value > 255 {
+			v.fail(src, path+".color", shape, "values must be 0-255")
+			break
+		}
+	}
+	if c.Radius <= 0 {
+		v.fail(src, path+".radius", shape, "must be a positive number")
+	}
+	if c.Flicker < 0 || c.Flicker > 1 {
+		v.fail(src, path+".flicker", shape, "must be between 0 and 1")
+	}
+	v.length(src, path+".offset", shape, c.Offset, 3, false)
+}
@@ -0,0 +1,67 @@
+package shapes
+
+// Mask marks which unit cells of a shape's size are solid. Shapes without one are solid everywhere.
+type Mask struct {
+	W, H, D int
+	cells   []bool
+}
+
+func newMask(size [3]float32) *Mask {
+	w, h, d := int(size[0]), int(size[1]), int(size[2])
+	if d < 1 {
+		d = 1
+	}
+	return &Mask{w, h, d, make([]bool, w*h*d)}
+}
+
+// Solid is true if the cell at x,y,z (relative to the shape's origin) blocks movement.
+func (mask *Mask) Solid(x, y, z int) bool {
+	if x < 0 || y < 0 || z < 0 || x >= mask.W || y >= mask.H || z >= mask.D {
+		return false
+	}
+	return mask.cells[(z*mask.H+y)*mask.W+x]
+}
+
+// NewMask makes a mask of the size from z layers of rows, where '#' is solid, like solidLayers in the config.
+func NewMask(size [3]float32, solidLayers [][]string) *Mask {
+	return newShapeMask(size, &ShapeConfig{SolidLayers: solidLayers})
+}
+
+func (mask *Mask) swapXY() *Mask {
+	swapped := &Mask{mask.H, mask.W, mask.D, make([]bool, len(mask.cells))}
+	for z := 0; z < mask.D; z++ {
+		for y := 0; y < mask.H; y++ {
+			for x := 0; x < mask.W; x++ {
+				if mask.Solid(x, y, z) {
+					swapped.set(y, x, z)
+				}
+			}
+		}
+	}
+	return swapped
+}
+
+func (mask *Mask) set(x, y, z int) {
+	mask.cells[(z*mask.H+y)*mask.W+x] = true
+}
+
+// a mask is either a list of solid cells, or a list of z layers with a string per row where '#' is solid
+func newShapeMask(size [3]float32, shapeDef *ShapeConfig) *Mask {
+	if len(shapeDef.Solid) == 0 && len(shapeDef.SolidLayers) == 0 {
+		return nil
+	}
+	mask := newMask(size)
+	for _, cell := range shapeDef.Solid {
+		mask.set(int(cell[0]), int(cell[1]), int(cell[2]))
+	}
+	for z, layer := range shapeDef.SolidLayers {
+		for y, row := range layer {
+			for x, c := range row {
+				if c == '#' {
+					mask.set(x, y, z)
+				}
+			}
+		}
+	}
+	return mask
+}
@@ -0,0 +1,133 @@
+package shapes
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadTestSheet loads the shapes from a generated sheet image whose columns all have different colors
+func loadTestSheet(t *testing.T, id int, defs ...ShapeConfig) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 0, 0xff})
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "sheet.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	oldShapes, oldNames, oldImages, oldSources := Shapes, Names, Images, ImageSources
+	t.Cleanup(func() {
+		Shapes, Names, Images, ImageSources = oldShapes, oldNames, oldImages, oldSources
+	})
+	Shapes, Names, Images, ImageSources = nil, map[string]int{}, nil, nil
+	sheet := SheetConfig{Id: &id, Image: "sheet.png", Dir: dir, Dpi: 96, Grid: GridConfig{Units: []float64{8, 4}}, Shapes: defs}
+	if err := InitShapes(dir, []SheetConfig{sheet}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddMirror(t *testing.T) {
+	tests := []struct {
+		name   string
+		def    ShapeConfig
+		size   [3]float32
+		offset [3]float32
+	}{
+		{
+			"square",
+			ShapeConfig{Name: "rock", Pos: []float64{0, 0}, Size: []float64{1, 1, 1}, Mirror: "rock.flipped"},
+			[3]float32{1, 1, 1},
+			[3]float32{},
+		},
+		{
+			"masked",
+			ShapeConfig{
+				Name: "wall.n", Pos: []float64{0, 0}, Size: []float64{3, 1, 2}, Offset: []float64{1, 0, 0.5}, Mirror: "wall.w",
+				SolidLayers: [][]string{{"#.#"}, {"##."}},
+			},
+			[3]float32{1, 3, 2},
+			[3]float32{0, 1, 0.5},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loadTestSheet(t, 2, ShapeConfig{Name: "floor", Pos: []float64{40, 0}, Size: []float64{1, 1, 0}}, test.def)
+			shape := Shapes[Names[test.def.Name]]
+			mirror := Shapes[Names[test.def.Mirror]]
+			if shape.Index != 2*0x100+1 || mirror.Index != shape.Index+MIRROR_OFFSET {
+				t.Errorf("got indexes %d and %d, expected %d and %d", shape.Index, mirror.Index, 2*0x100+1, shape.Index+MIRROR_OFFSET)
+			}
+			if mirror.Size != test.size || mirror.Offset != test.offset {
+				t.Errorf("got size %v offset %v, expected %v %v", mirror.Size, mirror.Offset, test.size, test.offset)
+			}
+			if !mirror.Mirror || shape.Mirror || mirror.Mirrored != shape || shape.Mirrored != mirror {
+				t.Errorf("the shape and its mirror should point at each other")
+			}
+			if shape.Rotated != mirror || mirror.Rotated != shape {
+				t.Errorf("the rotate key should switch between the shape and its mirror")
+			}
+			if (shape.Mask == nil) != (mirror.Mask == nil) {
+				t.Fatalf("only one of the shape and its mirror has a mask")
+			}
+			if shape.Mask != nil {
+				if mirror.Mask.W != shape.Mask.H || mirror.Mask.H != shape.Mask.W || mirror.Mask.D != shape.Mask.D {
+					t.Errorf("got a %dx%dx%d mask, expected %dx%dx%d", mirror.Mask.W, mirror.Mask.H, mirror.Mask.D, shape.Mask.H, shape.Mask.W, shape.Mask.D)
+				}
+				for z := 0; z < shape.Mask.D; z++ {
+					for y := 0; y < shape.Mask.H; y++ {
+						for x := 0; x < shape.Mask.W; x++ {
+							if shape.Mask.Solid(x, y, z) != mirror.Mask.Solid(y, x, z) {
+								t.Errorf("cell %d,%d,%d is solid %v, but %d,%d,%d of the mirror is not", x, y, z, shape.Mask.Solid(x, y, z), y, x, z)
+							}
+						}
+					}
+				}
+			}
+			bounds := shape.Image.Bounds()
+			if mirror.Image.Bounds() != bounds {
+				t.Fatalf("got a %v thumbnail, expected %v", mirror.Image.Bounds(), bounds)
+			}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if mirror.Image.At(x, y) != shape.Image.At(bounds.Max.X-1-(x-bounds.Min.X), y) {
+						t.Fatalf("the thumbnail isn't flipped at %d,%d", x, y)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRotations(t *testing.T) {
+	loadTestSheet(t, 0,
+		ShapeConfig{Name: "door.n", Pos: []float64{0, 0}, Size: []float64{2, 1, 2}, Mirror: "door.w"},
+		ShapeConfig{Name: "door.e", Pos: []float64{24, 0}, Size: []float64{2, 1, 2}, Mirror: "door.s", Rotations: []string{"door.n", "door.e", "door.w", "door.s"}},
+		ShapeConfig{Name: "pillar", Pos: []float64{48, 0}, Size: []float64{1, 1, 2}},
+	)
+	tests := []struct {
+		shape, rotated string
+	}{
+		{"door.n", "door.e"},
+		{"door.e", "door.w"},
+		{"door.w", "door.s"},
+		{"door.s", "door.n"},
+		{"pillar", ""},
+	}
+	for _, test := range tests {
+		rotated := Shapes[Names[test.shape]].Rotated
+		if test.rotated == "" && rotated != nil || test.rotated != "" && (rotated == nil || rotated.Name != test.rotated) {
+			t.Errorf("%s should rotate to '%s', got %v", test.shape, test.rotated, rotated)
+		}
+	}
+}
@@ -33,10 +33,31 @@ type TextureCoords struct {
 }
 
 type Animation struct {
-	Name          string
-	Steps         int
-	Tex           map[Direction][]*TextureCoords
-	AnimationStep int
+	Name  string
+	Steps int
+	Tex   map[Direction][]*TextureCoords
+	Mode  AnimationMode
+	// seconds per step, when missing the speed given to setAnimation is used
+	Durations map[Direction][]float64
+}
+
+type AnimationMode int
+
+const (
+	ANIMATION_LOOP AnimationMode = iota
+	// play once and go back to the first frame
+	ANIMATION_ONCE
+	ANIMATION_PINGPONG
+	// play once and stay on the last frame
+	ANIMATION_HOLD
+)
+
+var AnimationModes = map[string]AnimationMode{
+	"":         ANIMATION_LOOP,
+	"loop":     ANIMATION_LOOP,
+	"once":     ANIMATION_ONCE,
+	"pingpong": ANIMATION_PINGPONG,
+	"hold":     ANIMATION_HOLD,
 }
 
 const alphaMinDefault = 0.35
@@ -61,184 +82,268 @@ type Shape struct {
 	BreatheEnabled bool
 	NoSupport      bool
 	IsExtra        bool
+	Props          map[string]interface{}
+	WalkCost       int
+	BlocksSight    bool
+	Emissive       bool
+	Light          *Light
+	// nil if the whole size is solid
+	Mask *Mask
+	// drawn flipped horizontally
+	Mirror bool
+	// the generated mirror image of this shape, or the shape this one mirrors
+	Mirrored *Shape
+	// the next facing for the editor's rotate key
+	Rotated *Shape
+	// placing a shape with variants places one of them
+	Variants     []*Variant
+	VariantOf    []*Shape
+	variantTotal float64
 }
 
+// shape props with a meaning to the engine
+const PROP_WALK_COST = "walkCost"
+const PROP_BLOCKS_SIGHT = "blocksSight"
+
+// mirrors are numbered in their own range above the sheets, at their source shape's number plus this
+const MIRROR_OFFSET = (MAX_SHEET_ID + 1) * 0x100
+
 var Shapes []*Shape
 var Names map[string]int = map[string]int{}
 var Images []image.Image
 
+// the files each of Images was built from
+var ImageSources [][]string
+
 // some pre-defined animations
 const ANIMATION_MOVE = 0
 const ANIMATION_STAND = 1
 const ANIMATION_ATTACK = 2
 
 // but more can be added
-var AnimationNames map[string]int = map[string]int{
-	"move":   ANIMATION_MOVE,
-	"stand":  ANIMATION_STAND,
-	"attack": ANIMATION_ATTACK,
+var AnimationNames map[string]int = defaultAnimationNames()
+
+func defaultAnimationNames() map[string]int {
+	return map[string]int{
+		"move":   ANIMATION_MOVE,
+		"stand":  ANIMATION_STAND,
+		"attack": ANIMATION_ATTACK,
+	}
+}
+
+// Reload clears all loaded shapes and images and calls load to fill them again.
+// If load fails, the previous shapes are put back.
+func Reload(load func() error) error {
+	oldShapes, oldNames, oldImages, oldSources, oldAnimationNames := Shapes, Names, Images, ImageSources, AnimationNames
+	Shapes = nil
+	Names = map[string]int{}
+	Images = nil
+	ImageSources = nil
+	AnimationNames = defaultAnimationNames()
+	err := load()
+	if err != nil {
+		Shapes, Names, Images, ImageSources, AnimationNames = oldShapes, oldNames, oldImages, oldSources, oldAnimationNames
+	}
+	return err
 }
 
-func InitShapes(gameDir string, data []map[string]interface{}) error {
-	for _, block := range data {
-		imgFile := block["image"].(string)
-		shapes := block["shapes"].([]interface{})
-		fmt.Printf("Processing %s - %d shapes...\n", imgFile, len(shapes))
+func InitShapes(gameDir string, data []SheetConfig) error {
+	edges := []*ShapeConfig{}
+	groups := []*ShapeConfig{}
+	rotations := []*ShapeConfig{}
+	for i := range data {
+		block := &data[i]
+		fmt.Printf("Processing %s - %d shapes...\n", block.Image, len(block.Shapes))
 
 		// per-image meta data
-		grid := block["grid"].(map[string]interface{})
-		units := grid["units"].([]interface{})
-		dpi := block["dpi"].(float64)
 		shapeMeta := &ShapeMeta{
-			DpiMultiplier: float32(dpi) / 96.0,
-			UnitPixels:    [2]int{int(units[0].(float64)), int(units[1].(float64))},
+			DpiMultiplier: float32(block.Dpi) / 96.0,
+			UnitPixels:    [2]int{int(block.Grid.Units[0]), int(block.Grid.Units[1])},
 		}
 
-		img, err := loadImage(filepath.Join(gameDir, "images", imgFile))
+		dir := block.Dir
+		if dir == "" {
+			dir = filepath.Join(gameDir, "images")
+		}
+		img, imageIndex, err := addImage(filepath.Join(dir, block.Image))
 		if err != nil {
 			return err
 		}
-		imageIndex := len(Images)
-		Images = append(Images, img)
-		for index, s := range shapes {
-			shapeDef := s.(map[string]interface{})
-			name := shapeDef["name"].(string)
-			appendShape(index, name, shapeDef, imageIndex, img, shapeMeta)
+		mirrors := []*ShapeConfig{}
+		for index := range block.Shapes {
+			shapeDef := &block.Shapes[index]
+			appendShape(*block.Id*0x100+index, shapeDef, imageIndex, img, shapeMeta)
+			if shapeDef.Mirror != "" {
+				mirrors = append(mirrors, shapeDef)
+			}
+			if shapeDef.Ref != "" {
+				edges = append(edges, shapeDef)
+			}
+			if len(shapeDef.Variants) > 0 {
+				groups = append(groups, shapeDef)
+			}
+			if len(shapeDef.Rotations) > 0 {
+				rotations = append(rotations, shapeDef)
+			}
+		}
+		for _, shapeDef := range mirrors {
+			shape := Shapes[Names[shapeDef.Name]]
+			addMirror(shape, shapeDef.Mirror, shape.Index+MIRROR_OFFSET)
+		}
+	}
+
+	// edges are linked once all shapes are known, so a ref can point anywhere
+	for _, shapeDef := range edges {
+		addEdge(shapeDef)
+	}
+	for _, shapeDef := range groups {
+		addVariants(shapeDef)
+	}
+	for _, shapeDef := range rotations {
+		for i, name := range shapeDef.Rotations {
+			next := shapeDef.Rotations[(i+1)%len(shapeDef.Rotations)]
+			findShape(name).Rotated = findShape(next)
 		}
 	}
 	fmt.Printf("Loaded %d shapes.\n", len(Shapes))
 	return nil
 }
 
-func appendShape(index int, name string, shapeDef map[string]interface{}, imageIndex int, img image.Image, shapeMeta *ShapeMeta) {
+func appendShape(shapeIndex int, shapeDef *ShapeConfig, imageIndex int, img image.Image, shapeMeta *ShapeMeta) {
 	// size
-	sizeI := shapeDef["size"].([]interface{})
-	size := [3]float32{float32(sizeI[0].(float64)), float32(sizeI[1].(float64)), float32(math.Max(sizeI[2].(float64), 0.1))}
+	size := [3]float32{float32(shapeDef.Size[0]), float32(shapeDef.Size[1]), float32(math.Max(shapeDef.Size[2], 0.1))}
 
 	// pixel bounding box
-	posI := shapeDef["pos"].([]interface{})
-	px := float32(posI[0].(float64)) * shapeMeta.DpiMultiplier
-	py := float32(posI[1].(float64)) * shapeMeta.DpiMultiplier
+	px := float32(shapeDef.Pos[0]) * shapeMeta.DpiMultiplier
+	py := float32(shapeDef.Pos[1]) * shapeMeta.DpiMultiplier
 	unitPixelX := float32(shapeMeta.UnitPixels[0])
 	unitPixelY := float32(shapeMeta.UnitPixels[1])
 	pw := (size[0] + size[1]) * unitPixelX * shapeMeta.DpiMultiplier
 	ph := (size[0] + size[1] + size[2]) * unitPixelY * shapeMeta.DpiMultiplier
 
-	// fudge
-	fudge64, ok := shapeDef["fudge"].(float64)
-	var fudge float32 = 0
-	if ok {
-		fudge = float32(fudge64)
-	}
-
 	// alphaMin
-	alphaMin64, ok := shapeDef["alphaMin"].(float64)
 	var alphaMin float32 = alphaMinDefault
-	if ok {
-		alphaMin = float32(alphaMin64)
+	if shapeDef.AlphaMin != nil {
+		alphaMin = float32(*shapeDef.AlphaMin)
 	}
 
 	// offset
 	offset := [3]float32{}
-	if offsetI, ok := shapeDef["offset"].([]interface{}); ok {
-		offset[0] = float32(offsetI[0].(float64))
-		offset[1] = float32(offsetI[1].(float64))
-		offset[2] = float32(offsetI[2].(float64))
-	}
-
-	groupF, ok := shapeDef["group"].(float64)
-	var group int
-	if ok {
-		group = int(groupF)
+	if len(shapeDef.Offset) == 3 {
+		offset[0] = float32(shapeDef.Offset[0])
+		offset[1] = float32(shapeDef.Offset[1])
+		offset[2] = float32(shapeDef.Offset[2])
 	}
 
 	shape := newShape(
-		imageIndex*0x100+index, // each image can contain max 256 shapes
-		name,
-		group,
+		shapeIndex,
+		shapeDef.Name,
+		shapeDef.Group,
 		size,
 		px, py, pw, ph,
 		img,
-		fudge,
+		float32(shapeDef.Fudge),
 		alphaMin,
 		imageIndex,
 		shapeMeta,
 		offset,
 	)
-	shape.addExtras(shapeDef)
-
-	// edges
-	refName, ok := shapeDef["ref"]
-	if ok {
-		targetI, ok := shapeDef["target"]
-		var target string
-		if ok == false {
-			target = "default"
-		} else {
-			target = targetI.(string)
-		}
+	shape.addExtras(&shapeDef.ShapeFlags)
+	shape.setProps(shapeDef.Props)
+	shape.Mask = newShapeMask(size, shapeDef)
+	shape.EditorVisible = shapeDef.Ref == ""
 
-		parts := strings.Split(name, ".")
-		ref := findShape(refName.(string))
-		if _, ok := ref.Edges[target]; ok == false {
-			ref.Edges[target] = map[string][]*Shape{}
-		}
-		if _, ok := ref.Edges[target][parts[2]]; ok {
-			ref.Edges[target][parts[2]] = append(ref.Edges[target][parts[2]], shape)
-		} else {
-			ref.Edges[target][parts[2]] = []*Shape{shape}
-		}
-	} else {
-		shape.EditorVisible = true
-	}
+	putShape(shape)
+}
 
+func putShape(shape *Shape) {
 	// add a gap, if needed
-	for len(Shapes) < shape.Index {
+	for len(Shapes) <= shape.Index {
 		Shapes = append(Shapes, nil)
 	}
-	// add shape
-	Shapes = append(Shapes, shape)
-	Names[name] = shape.Index
+	Shapes[shape.Index] = shape
+	Names[shape.Name] = shape.Index
 }
 
-func (shape *Shape) addExtras(shapeDef map[string]interface{}) {
-	// sway
-	if sway, ok := shapeDef["sway"].(bool); ok {
-		shape.SwayEnabled = sway
+func addMirror(shape *Shape, name string, index int) {
+	mirror := *shape
+	mirror.Index = index
+	mirror.Name = name
+	mirror.Size = [3]float32{shape.Size[1], shape.Size[0], shape.Size[2]}
+	mirror.Offset = [3]float32{shape.Offset[1], shape.Offset[0], shape.Offset[2]}
+	mirror.Mirror = !shape.Mirror
+	mirror.Mirrored = shape
+	mirror.Edges = map[string]map[string][]*Shape{}
+	mirror.Variants = nil
+	mirror.VariantOf = nil
+	mirror.variantTotal = 0
+	if shape.Mask != nil {
+		mirror.Mask = shape.Mask.swapXY()
 	}
-	// bob
-	if bob, ok := shapeDef["bob"].(bool); ok {
-		shape.BobEnabled = bob
+	if shape.Light != nil {
+		light := *shape.Light
+		light.Offset = [3]float32{light.Offset[1], light.Offset[0], light.Offset[2]}
+		mirror.Light = &light
 	}
-	if bob, ok := shapeDef["breathe"].(bool); ok {
-		shape.BreatheEnabled = bob
+	bounds := shape.Image.Bounds()
+	mirror.Image = image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			mirror.Image.Set(bounds.Max.X-1-(x-bounds.Min.X), y, shape.Image.At(x, y))
+		}
 	}
-	if bob, ok := shapeDef["nosupport"].(bool); ok {
-		shape.NoSupport = bob
+	shape.Mirrored = &mirror
+	shape.Rotated = &mirror
+	mirror.Rotated = shape
+	putShape(&mirror)
+}
+
+func addEdge(shapeDef *ShapeConfig) {
+	target := shapeDef.Target
+	if target == "" {
+		target = "default"
 	}
-	// extra
-	if extra, ok := shapeDef["extra"].(bool); ok {
-		shape.IsExtra = extra
+
+	shape := Shapes[Names[shapeDef.Name]]
+	parts := strings.Split(shapeDef.Name, ".")
+	ref := findShape(shapeDef.Ref)
+	if _, ok := ref.Edges[target]; ok == false {
+		ref.Edges[target] = map[string][]*Shape{}
 	}
+	ref.Edges[target][parts[2]] = append(ref.Edges[target][parts[2]], shape)
 }
 
-func (shape *Shape) HasEdges(shapeName string) bool {
-	_, ok := shape.Edges[shapeName]
-	if ok {
-		return true
+func (shape *Shape) addExtras(flags *ShapeFlags) {
+	shape.SwayEnabled = flags.Sway
+	shape.BobEnabled = flags.Bob
+	shape.BreatheEnabled = flags.Breathe
+	shape.NoSupport = flags.NoSupport
+	shape.IsExtra = flags.Extra
+	shape.Emissive = flags.Emissive
+	if flags.Light != nil {
+		shape.Light = newLight(shape, flags.Light)
 	}
-	_, ok = shape.Edges["default"]
-	if ok {
-		return true
+}
+
+func (shape *Shape) setProps(props map[string]interface{}) {
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+	shape.Props = props
+	shape.WalkCost = 1
+	if cost, ok := props[PROP_WALK_COST].(float64); ok {
+		shape.WalkCost = int(cost)
 	}
-	return false
+	shape.BlocksSight, _ = props[PROP_BLOCKS_SIGHT].(bool)
+}
+
+func (shape *Shape) HasEdges(shapeName string) bool {
+	_, ok := shape.EdgeBase().edgesFor(shapeName)
+	return ok
 }
 
 func (shape *Shape) GetEdge(shapeName, edgeName string) *Shape {
-	edgeMap, ok := shape.Edges[shapeName]
-	if ok == false {
-		edgeMap, ok = shape.Edges["default"]
-	}
+	edgeMap, ok := shape.EdgeBase().edgesFor(shapeName)
 	if ok == false {
 		fmt.Printf("No edges for shape %s\n", shape.Name)
 		return nil
@@ -250,9 +355,38 @@ func (shape *Shape) GetEdge(shapeName, edgeName string) *Shape {
 	return nil
 }
 
+// EdgeBase is the shape whose edges are used: this one, or if it has none the variant group it was picked from.
+func (shape *Shape) EdgeBase() *Shape {
+	if len(shape.Edges) > 0 {
+		return shape
+	}
+	for _, group := range shape.VariantOf {
+		if len(group.Edges) > 0 {
+			return group
+		}
+	}
+	return shape
+}
+
+// edgesFor finds the edges next to a shape: by its name, the variant groups it was picked from, or the default ones
+func (shape *Shape) edgesFor(shapeName string) (map[string][]*Shape, bool) {
+	if edgeMap, ok := shape.Edges[shapeName]; ok {
+		return edgeMap, true
+	}
+	if index, ok := Names[shapeName]; ok {
+		for _, group := range Shapes[index].VariantOf {
+			if edgeMap, ok := shape.Edges[group.Name]; ok {
+				return edgeMap, true
+			}
+		}
+	}
+	edgeMap, ok := shape.Edges["default"]
+	return edgeMap, ok
+}
+
 func findShape(name string) *Shape {
 	for _, s := range Shapes {
-		if s.Name == name {
+		if s != nil && s.Name == name {
 			return s
 		}
 	}
@@ -295,79 +429,122 @@ func NewTextureCoords(imageBounds image.Rectangle, px, py, pw, ph float32) *Text
 	}
 }
 
-func InitCreatures(gameDir string, data []map[string]interface{}) error {
+func InitCreatures(gameDir string, data []CreatureConfig) error {
 	// create a large image to store all the animated textures
-	for _, block := range data {
-		name := block["name"].(string)
+	for i := range data {
+		block := &data[i]
+		name := block.Name
 		fmt.Printf("\tProcessing creature: %s\n", name)
-		img, err := loadImage(filepath.Join(gameDir, "creatures", fmt.Sprintf("%s.png", name)))
+
+		var shape *Shape
+		var err error
+		if block.Sheet != "" {
+			shape, err = importCreature(gameDir, block)
+		} else {
+			shape, err = loadCreature(gameDir, block)
+		}
 		if err != nil {
 			return err
 		}
-		imageIndex := len(Images)
-		Images = append(Images, img)
-
-		sizeI := block["size"].([]interface{})
-		size := [3]float32{float32(sizeI[0].(float64)), float32(sizeI[1].(float64)), float32(sizeI[2].(float64))}
-
-		shape := &Shape{
-			Index:      imageIndex * 0x100,
-			Name:       name,
-			Size:       size,
-			ImageIndex: imageIndex,
-			Animations: map[int]*Animation{},
-			AlphaMin:   alphaMinDefault,
-		}
-		shape.addExtras(block)
 
-		// add a gap, if needed
-		for len(Shapes) < shape.Index {
-			Shapes = append(Shapes, nil)
+		putShape(shape)
+	}
+	return nil
+}
+
+// maps save creatures placed by scripts, so they're numbered by their id like the sheets
+func newCreature(block *CreatureConfig, imageIndex int) *Shape {
+	shape := &Shape{
+		Index:      *block.Id * 0x100,
+		Name:       block.Name,
+		Size:       [3]float32{float32(block.Size[0]), float32(block.Size[1]), float32(block.Size[2])},
+		ImageIndex: imageIndex,
+		Animations: map[int]*Animation{},
+		AlphaMin:   alphaMinDefault,
+	}
+	shape.addExtras(&block.ShapeFlags)
+	shape.setProps(block.Props)
+	return shape
+}
+
+// a creature image is a single row of same sized frames, in the order of the frames config
+func loadCreature(gameDir string, block *CreatureConfig) (*Shape, error) {
+	img, imageIndex, err := addImage(filepath.Join(gameDir, "creatures", fmt.Sprintf("%s.png", block.Name)))
+	if err != nil {
+		return nil, err
+	}
+	shape := newCreature(block, imageIndex)
+
+	dim := [2]int{int(block.Dim[0]), int(block.Dim[1])}
+
+	xpos := 0
+	for _, frame := range block.Frames {
+		a := &Animation{
+			Name:      frame.Name,
+			Steps:     frame.Steps,
+			Tex:       map[Direction][]*TextureCoords{},
+			Mode:      AnimationModes[frame.Mode],
+			Durations: map[Direction][]float64{},
 		}
-		Shapes = append(Shapes, shape)
-		Names[name] = shape.Index
-
-		dimI := block["dim"].([]interface{})
-		dim := [2]int{int(dimI[0].(float64)), int(dimI[1].(float64))}
-
-		frames := block["frames"].([]interface{})
-		xpos := 0
-		for _, frameBlock := range frames {
-			frame := frameBlock.(map[string]interface{})
-			a := &Animation{
-				Name:  frame["name"].(string),
-				Steps: int(frame["steps"].(float64)),
-				Tex:   map[Direction][]*TextureCoords{},
+		for _, dir := range frame.Dirs {
+			if len(frame.Durations) > 0 {
+				a.Durations[Directions[dir]] = stepDurations(frame.Durations, a.Steps)
 			}
-			dirs := frame["dirs"].([]interface{})
-			for _, dirI := range dirs {
-				dirFrames := []*TextureCoords{}
-				for step := 0; step < a.Steps; step++ {
-					dirFrames = append(dirFrames, NewTextureCoords(
-						img.Bounds(),
-						float32(xpos), 0,
-						float32(dim[0]), float32(dim[1]),
-					))
-					if shape.Tex == nil {
-						shape.Tex = dirFrames[0]
-					}
-					xpos += dim[0]
+			dirFrames := []*TextureCoords{}
+			for step := 0; step < a.Steps; step++ {
+				dirFrames = append(dirFrames, NewTextureCoords(
+					img.Bounds(),
+					float32(xpos), 0,
+					float32(dim[0]), float32(dim[1]),
+				))
+				if shape.Tex == nil {
+					shape.Tex = dirFrames[0]
 				}
-				dir := dirI.(string)
-				fmt.Printf("\t\t\tadding %d steps for: %s\n", a.Steps, dir)
-				a.Tex[Directions[dir]] = dirFrames
-			}
-			frameName := frame["name"].(string)
-			animationIndex, ok := AnimationNames[frameName]
-			if ok == false {
-				animationIndex = len(AnimationNames)
-				AnimationNames[frameName] = animationIndex
+				xpos += dim[0]
 			}
-			fmt.Printf("\t\tadding animations for: %s\n", frameName)
-			shape.Animations[animationIndex] = a
+			fmt.Printf("\t\t\tadding %d steps for: %s\n", a.Steps, dir)
+			a.Tex[Directions[dir]] = dirFrames
 		}
+		fmt.Printf("\t\tadding animations for: %s\n", frame.Name)
+		shape.Animations[animationIndex(frame.Name)] = a
 	}
-	return nil
+	return shape, nil
+}
+
+// durations are in milliseconds, like in Aseprite. A single value is used for every step.
+func stepDurations(durations []float64, steps int) []float64 {
+	seconds := make([]float64, steps)
+	for i := range seconds {
+		d := durations[0]
+		if len(durations) > 1 {
+			d = durations[i]
+		}
+		seconds[i] = d / 1000
+	}
+	return seconds
+}
+
+func animationIndex(name string) int {
+	index, ok := AnimationNames[name]
+	if ok == false {
+		index = len(AnimationNames)
+		AnimationNames[name] = index
+	}
+	return index
+}
+
+func addImage(path string) (image.Image, int, error) {
+	img, err := loadImage(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return img, appendImage(img, path), nil
+}
+
+func appendImage(img image.Image, sources ...string) int {
+	Images = append(Images, img)
+	ImageSources = append(ImageSources, sources)
+	return len(Images) - 1
 }
 
 func loadImage(path string) (image.Image, error) {
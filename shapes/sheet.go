@@ -0,0 +1,317 @@
+package shapes
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uzudil/isongn/util"
+)
+
+// a sprite sheet as exported by Aseprite or TexturePacker (json hash or json array)
+type spriteSheet struct {
+	Frames json.RawMessage `json:"frames"`
+	Meta   sheetMeta       `json:"meta"`
+}
+
+type sheetMeta struct {
+	Image     string     `json:"image"`
+	FrameTags []sheetTag `json:"frameTags"`
+}
+
+type sheetTag struct {
+	Name      string `json:"name"`
+	From      int    `json:"from"`
+	To        int    `json:"to"`
+	Direction string `json:"direction"`
+}
+
+type sheetRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type sheetFrame struct {
+	Filename         string    `json:"filename"`
+	Frame            sheetRect `json:"frame"`
+	Rotated          bool      `json:"rotated"`
+	Trimmed          bool      `json:"trimmed"`
+	SpriteSourceSize sheetRect `json:"spriteSourceSize"`
+	SourceSize       sheetRect `json:"sourceSize"`
+	Duration         float64   `json:"duration"`
+}
+
+// the untrimmed size of the frame
+func (f *sheetFrame) size() (int, int) {
+	if f.SourceSize.W > 0 && f.SourceSize.H > 0 {
+		return f.SourceSize.W, f.SourceSize.H
+	}
+	return f.Frame.W, f.Frame.H
+}
+
+// frames of one animation in one direction, as indexes into the sheet's frames
+type sheetAnimation struct {
+	name   string
+	dir    Direction
+	frames []int
+}
+
+// importCreature builds a creature from a sprite sheet export. The frames are copied into a strip
+// of same sized cells, so a trimmed frame is put back where it was in the untrimmed sprite.
+func importCreature(gameDir string, block *CreatureConfig) (*Shape, error) {
+	sheetPath := filepath.Join(gameDir, "creatures", block.Sheet)
+	frames, tags, imagePath, err := readSpriteSheet(sheetPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: no frames", sheetPath)
+	}
+	src, err := loadImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []Direction{}
+	for _, name := range block.Dirs {
+		dirs = append(dirs, Directions[name])
+	}
+	if len(dirs) == 0 {
+		for dir := DIR_W; dir < DIR_NONE; dir++ {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var anims []*sheetAnimation
+	if len(tags) > 0 {
+		anims, err = tagAnimations(sheetPath, frames, tags, dirs)
+	} else {
+		anims, err = frameNameAnimations(sheetPath, frames, dirs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cellW, cellH := 0, 0
+	for i := range frames {
+		w, h := frames[i].size()
+		if w > cellW {
+			cellW = w
+		}
+		if h > cellH {
+			cellH = h
+		}
+	}
+	strip := image.NewRGBA(image.Rect(0, 0, cellW*len(frames), cellH))
+	for i := range frames {
+		blitFrame(strip, src, &frames[i], i*cellW, cellW, cellH)
+	}
+	imageIndex := appendImage(strip, sheetPath, imagePath)
+	shape := newCreature(block, imageIndex)
+
+	bounds := strip.Bounds()
+	coords := make([]*TextureCoords, len(frames))
+	for i := range frames {
+		coords[i] = NewTextureCoords(bounds, float32(i*cellW), 0, float32(cellW), float32(cellH))
+	}
+	for _, sa := range anims {
+		name := sa.name
+		if mapped, ok := block.Tags[name]; ok {
+			name = mapped
+		}
+		index := animationIndex(name)
+		a, ok := shape.Animations[index]
+		if !ok {
+			a = &Animation{
+				Name:      name,
+				Tex:       map[Direction][]*TextureCoords{},
+				Mode:      AnimationModes[block.Modes[name]],
+				Durations: map[Direction][]float64{},
+			}
+			shape.Animations[index] = a
+			fmt.Printf("\t\tadding animations for: %s\n", name)
+		}
+		steps := []*TextureCoords{}
+		durations := []float64{}
+		timed := false
+		for _, frame := range sa.frames {
+			steps = append(steps, coords[frame])
+			durations = append(durations, frames[frame].Duration)
+			timed = timed || frames[frame].Duration > 0
+		}
+		a.Tex[sa.dir] = steps
+		if timed {
+			a.Durations[sa.dir] = stepDurations(durations, len(durations))
+		}
+		if len(steps) > a.Steps {
+			a.Steps = len(steps)
+		}
+	}
+	shape.Tex = coords[0]
+	if a, ok := shape.Animations[ANIMATION_STAND]; ok {
+		if steps, ok := a.Tex[DIR_S]; ok {
+			shape.Tex = steps[0]
+		}
+	}
+	return shape, nil
+}
+
+func readSpriteSheet(sheetPath string) ([]sheetFrame, []sheetTag, string, error) {
+	data, err := ioutil.ReadFile(sheetPath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	sheet := &spriteSheet{}
+	if err := util.DecodeJSON(sheetPath, data, sheet); err != nil {
+		return nil, nil, "", err
+	}
+	if sheet.Meta.Image == "" {
+		return nil, nil, "", fmt.Errorf("%s: missing meta.image", sheetPath)
+	}
+	frames, err := decodeFrames(sheet.Frames)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%s: frames: %v", sheetPath, err)
+	}
+	return frames, sheet.Meta.FrameTags, filepath.Join(filepath.Dir(sheetPath), sheet.Meta.Image), nil
+}
+
+// frames are either an array, or an object keyed by file name. The object's key order is the frame order.
+func decodeFrames(data json.RawMessage) ([]sheetFrame, error) {
+	frames := []sheetFrame{}
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		err := json.Unmarshal(data, &frames)
+		return frames, err
+	}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		frame := sheetFrame{}
+		if err := dec.Decode(&frame); err != nil {
+			return nil, err
+		}
+		if frame.Filename == "" {
+			frame.Filename = key.(string)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Aseprite tags name a range of frames: "walk_ne" is the walk animation facing ne,
+// a tag without a direction is used for all of them.
+func tagAnimations(sheetPath string, frames []sheetFrame, tags []sheetTag, dirs []Direction) ([]*sheetAnimation, error) {
+	anims := []*sheetAnimation{}
+	for _, tag := range tags {
+		if tag.From < 0 || tag.To >= len(frames) || tag.From > tag.To {
+			return nil, fmt.Errorf("%s: tag %s: bad frame range %d-%d", sheetPath, tag.Name, tag.From, tag.To)
+		}
+		steps := []int{}
+		for i := tag.From; i <= tag.To; i++ {
+			steps = append(steps, i)
+		}
+		switch tag.Direction {
+		case "", "forward":
+		case "reverse":
+			reverse(steps)
+		case "pingpong":
+			for i := len(steps) - 2; i > 0; i-- {
+				steps = append(steps, steps[i])
+			}
+		default:
+			return nil, fmt.Errorf("%s: tag %s: unknown direction %s", sheetPath, tag.Name, tag.Direction)
+		}
+		anims = append(anims, splitAnimation(tag.Name, steps, dirs)...)
+	}
+	return anims, nil
+}
+
+// without tags, frame names carry the animation, direction and step: "walk_ne_0.png" or "walk/ne/0001.png"
+func frameNameAnimations(sheetPath string, frames []sheetFrame, dirs []Direction) ([]*sheetAnimation, error) {
+	type step struct {
+		frame, n int
+	}
+	order := []string{}
+	groups := map[string][]step{}
+	for i, frame := range frames {
+		name := strings.TrimSuffix(frame.Filename, filepath.Ext(frame.Filename))
+		base := strings.TrimRightFunc(name, func(r rune) bool { return r >= '0' && r <= '9' })
+		n, err := strconv.Atoi(name[len(base):])
+		if err != nil {
+			// no step number, keep the order of the sheet
+			n = len(groups[base])
+		}
+		base = strings.TrimRight(base, "_.-/ ")
+		if base == "" {
+			return nil, fmt.Errorf("%s: can't find an animation name in frame %s", sheetPath, frame.Filename)
+		}
+		if _, ok := groups[base]; !ok {
+			order = append(order, base)
+		}
+		groups[base] = append(groups[base], step{i, n})
+	}
+	anims := []*sheetAnimation{}
+	for _, base := range order {
+		group := groups[base]
+		sort.SliceStable(group, func(a, b int) bool { return group[a].n < group[b].n })
+		steps := make([]int, len(group))
+		for i, s := range group {
+			steps[i] = s.frame
+		}
+		anims = append(anims, splitAnimation(base, steps, dirs)...)
+	}
+	return anims, nil
+}
+
+func splitAnimation(tag string, steps []int, dirs []Direction) []*sheetAnimation {
+	i := strings.LastIndexAny(tag, "_.-/ ")
+	if i > 0 {
+		if dir, ok := Directions[strings.ToLower(tag[i+1:])]; ok && dir != DIR_NONE {
+			return []*sheetAnimation{{tag[:i], dir, steps}}
+		}
+	}
+	anims := []*sheetAnimation{}
+	for _, dir := range dirs {
+		anims = append(anims, &sheetAnimation{tag, dir, steps})
+	}
+	return anims
+}
+
+func reverse(steps []int) {
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+}
+
+// blitFrame copies the frame into its cell: centered horizontally and standing on the bottom edge.
+func blitFrame(dst *image.RGBA, src image.Image, frame *sheetFrame, cellX, cellW, cellH int) {
+	srcW, srcH := frame.size()
+	x := cellX + (cellW-srcW)/2 + frame.SpriteSourceSize.X
+	y := cellH - srcH + frame.SpriteSourceSize.Y
+	w, h := frame.Frame.W, frame.Frame.H
+	if !frame.Rotated {
+		draw.Draw(dst, image.Rect(x, y, x+w, y+h), src, image.Point{frame.Frame.X, frame.Frame.Y}, draw.Src)
+		return
+	}
+	// TexturePacker stores rotated frames turned 90 degrees clockwise, w and h are the unrotated size
+	min := src.Bounds().Min
+	for dy := 0; dy < h; dy++ {
+		for dx := 0; dx < w; dx++ {
+			dst.Set(x+dx, y+dy, src.At(min.X+frame.Frame.X+h-1-dy, min.Y+frame.Frame.Y+dx))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package shapes
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		names []string
+		sizes [][2]int
+	}{
+		{
+			"hash keeps the key order",
+			`{"walk_1.png": {"frame": {"x": 0, "y": 0, "w": 4, "h": 8}},
+			  "walk_0.png": {"frame": {"x": 4, "y": 0, "w": 4, "h": 8}, "sourceSize": {"w": 6, "h": 10}}}`,
+			[]string{"walk_1.png", "walk_0.png"},
+			[][2]int{{4, 8}, {6, 10}},
+		},
+		{
+			"array",
+			`[{"filename": "a", "frame": {"w": 2, "h": 3}}, {"filename": "b", "frame": {"w": 5, "h": 1}}]`,
+			[]string{"a", "b"},
+			[][2]int{{2, 3}, {5, 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames, err := decodeFrames(json.RawMessage(test.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, sizes := []string{}, [][2]int{}
+			for i := range frames {
+				w, h := frames[i].size()
+				names = append(names, frames[i].Filename)
+				sizes = append(sizes, [2]int{w, h})
+			}
+			if !reflect.DeepEqual(names, test.names) || !reflect.DeepEqual(sizes, test.sizes) {
+				t.Errorf("got frames %v %v, expected %v %v", names, sizes, test.names, test.sizes)
+			}
+		})
+	}
+}
+
+// animations as "name dir frames", in order
+func describeAnimations(anims []*sheetAnimation) []string {
+	r := []string{}
+	for _, a := range anims {
+		r = append(r, fmt.Sprintf("%s %d %v", a.name, a.dir, a.frames))
+	}
+	return r
+}
+
+func TestTagAnimations(t *testing.T) {
+	frames := make([]sheetFrame, 4)
+	dirs := []Direction{DIR_N, DIR_S}
+	tests := []struct {
+		name     string
+		tags     []sheetTag
+		expected []string
+		err      bool
+	}{
+		{"with direction", []sheetTag{{Name: "walk_ne", From: 0, To: 2}}, []string{"walk 5 [0 1 2]"}, false},
+		{"without direction", []sheetTag{{Name: "idle", From: 3, To: 3}}, []string{"idle 6 [3]", "idle 2 [3]"}, false},
+		{"reverse", []sheetTag{{Name: "walk-w", From: 0, To: 2, Direction: "reverse"}}, []string{"walk 0 [2 1 0]"}, false},
+		{"pingpong", []sheetTag{{Name: "walk/w", From: 0, To: 3, Direction: "pingpong"}}, []string{"walk 0 [0 1 2 3 2 1]"}, false},
+		{"bad range", []sheetTag{{Name: "walk", From: 2, To: 4}}, nil, true},
+		{"bad direction", []sheetTag{{Name: "walk", From: 0, To: 1, Direction: "sideways"}}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			anims, err := tagAnimations("man.json", frames, test.tags, dirs)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeAnimations(anims); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFrameNameAnimations(t *testing.T) {
+	dirs := []Direction{DIR_S}
+	tests := []struct {
+		name     string
+		files    []string
+		expected []string
+		err      bool
+	}{
+		{"underscores", []string{"walk_ne_1.png", "walk_ne_0.png", "walk_sw_0.png"}, []string{"walk 5 [1 0]", "walk 1 [2]"}, false},
+		{"dirs", []string{"walk/n/0002.png", "walk/n/0001.png"}, []string{"walk 6 [1 0]"}, false},
+		{"no step number", []string{"idle.png", "idle.png"}, []string{"idle 2 [0 1]"}, false},
+		{"no name", []string{"0001.png"}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames := make([]sheetFrame, len(test.files))
+			for i, file := range test.files {
+				frames[i].Filename = file
+			}
+			anims, err := frameNameAnimations("man.json", frames, dirs)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeAnimations(anims); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package shapes
+
+// Variant is one of the shapes a variant group can place, picked in proportion to its weight.
+type Variant struct {
+	Shape  *Shape
+	Weight float64
+}
+
+func addVariants(shapeDef *ShapeConfig) {
+	shape := Shapes[Names[shapeDef.Name]]
+	for _, v := range shapeDef.Variants {
+		weight := 1.0
+		if v.Weight != nil {
+			weight = *v.Weight
+		}
+		variant := findShape(v.Name)
+		shape.Variants = append(shape.Variants, &Variant{variant, weight})
+		shape.variantTotal += weight
+		variant.VariantOf = append(variant.VariantOf, shape)
+	}
+}
+
+// PickVariant returns the variant to place at a position. The same position always gets the same variant,
+// so filling an area again doesn't change it. A shape without variants is returned as is.
+func (shape *Shape) PickVariant(x, y, z int) *Shape {
+	if len(shape.Variants) == 0 {
+		return shape
+	}
+	r := float64(positionHash(x, y, z)) / (1 << 32) * shape.variantTotal
+	for _, v := range shape.Variants {
+		if r < v.Weight {
+			return v.Shape
+		}
+		r -= v.Weight
+	}
+	return shape.Variants[len(shape.Variants)-1].Shape
+}
+
+// IsVariantOf is true if the shapes are the same, or other is a variant group this shape was picked from.
+func (shape *Shape) IsVariantOf(other *Shape) bool {
+	if shape == other {
+		return true
+	}
+	for _, group := range shape.VariantOf {
+		if group == other {
+			return true
+		}
+		for _, otherGroup := range other.VariantOf {
+			if group == otherGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func positionHash(x, y, z int) uint32 {
+	h := uint32(x)*73856093 ^ uint32(y)*19349663 ^ uint32(z)*83492791
+	h ^= h >> 13
+	h *= 0x5bd1e995
+	h ^= h >> 15
+	return h
+}
@@ -0,0 +1,53 @@
+package shapes
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPickVariant(t *testing.T) {
+	a, b, c := &Shape{Name: "a"}, &Shape{Name: "b"}, &Shape{Name: "c"}
+	tests := []struct {
+		name     string
+		variants []*Variant
+		// the expected share of each variant
+		shares []float64
+	}{
+		{"no variants", nil, nil},
+		{"one", []*Variant{{a, 1}}, []float64{1}},
+		{"even", []*Variant{{a, 1}, {b, 1}}, []float64{0.5, 0.5}},
+		{"weighted", []*Variant{{a, 3}, {b, 1}}, []float64{0.75, 0.25}},
+		{"three", []*Variant{{a, 2}, {b, 1}, {c, 1}}, []float64{0.5, 0.25, 0.25}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := &Shape{Name: "group", Variants: test.variants}
+			for _, v := range test.variants {
+				group.variantTotal += v.Weight
+			}
+			counts := map[*Shape]int{}
+			const n = 100
+			for x := 0; x < n; x++ {
+				for y := 0; y < n; y++ {
+					picked := group.PickVariant(x, y, 1)
+					if again := group.PickVariant(x, y, 1); again != picked {
+						t.Fatalf("%d,%d picked %s then %s", x, y, picked.Name, again.Name)
+					}
+					counts[picked]++
+				}
+			}
+			if len(test.variants) == 0 {
+				if counts[group] != n*n {
+					t.Errorf("a shape without variants should pick itself")
+				}
+				return
+			}
+			for i, v := range test.variants {
+				share := float64(counts[v.Shape]) / (n * n)
+				if math.Abs(share-test.shares[i]) > 0.03 {
+					t.Errorf("%s picked %.3f of the time, expected %.3f", v.Shape.Name, share, test.shares[i])
+				}
+			}
+		})
+	}
+}
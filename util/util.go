@@ -1,6 +1,8 @@
 package util
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"math"
 
@@ -122,3 +124,44 @@ func AbsInt(x int) int {
 	}
 	return x
 }
+
+func MinInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func MaxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// DecodeJSON unmarshals data into v. Syntax and type errors are reported with the file name, line and column.
+func DecodeJSON(file string, data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line, col := lineCol(data, e.Offset)
+		return fmt.Errorf("%s:%d:%d: %v", file, line, col, e)
+	case *json.UnmarshalTypeError:
+		line, col := lineCol(data, e.Offset)
+		return fmt.Errorf("%s:%d:%d: %s: expected %v, got %s", file, line, col, e.Field, e.Type, e.Value)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("%s: %v", file, err)
+	}
+}
+
+func lineCol(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -16,7 +16,7 @@ import (
 const (
 	SECTION_SIZE   = 200
 	SECTION_Z_SIZE = 24
-	VERSION        = 4
+	VERSION        = 6
 	EDITOR_MODE    = 0
 	RUNNER_MODE    = 1
 )
@@ -33,6 +33,18 @@ type PositionList struct {
 	Shapes []int
 }
 
+// Look is how a placed shape is drawn: multiplied by Tint and its colours remapped by the named palette.
+// It belongs to the shape at the position, not to the extras there, and goes when the shape is replaced.
+type Look struct {
+	Tint    [4]float32
+	Palette string
+}
+
+type LookAt struct {
+	X, Y, Z int
+	Look    Look
+}
+
 type Section struct {
 	X, Y     int
 	position [SECTION_SIZE][SECTION_SIZE][SECTION_Z_SIZE]Position
@@ -40,6 +52,9 @@ type Section struct {
 	// extra non blocking shapes: plants, items, etc.
 	extras [SECTION_SIZE][SECTION_SIZE][SECTION_Z_SIZE]PositionList
 	data   map[string]interface{}
+	looks  map[[3]int]*Look
+	// the columns the player has seen
+	explored [SECTION_SIZE][SECTION_SIZE]bool
 }
 
 type SectionCache struct {
@@ -103,6 +118,9 @@ func (loader *Loader) GetEdge(x, y int) (int, bool) {
 
 func (loader *Loader) SetShape(x, y, z int, shapeIndex int) bool {
 	section, atomX, atomY, atomZ := loader.getPosInSection(x, y, z)
+	if section.position[atomX][atomY][atomZ].Shape != shapeIndex+1 {
+		delete(section.looks, [3]int{atomX, atomY, atomZ})
+	}
 	section.position[atomX][atomY][atomZ].Shape = shapeIndex + 1
 	return true
 }
@@ -112,6 +130,7 @@ func (loader *Loader) EraseShape(x, y, z int) bool {
 	shapeIndex := section.position[atomX][atomY][atomZ].Shape
 	if shapeIndex > 0 {
 		section.position[atomX][atomY][atomZ].Shape = 0
+		delete(section.looks, [3]int{atomX, atomY, atomZ})
 		return true
 	}
 	return false
@@ -126,6 +145,31 @@ func (loader *Loader) GetShape(worldX, worldY, worldZ int) (int, bool) {
 	return shapeIndex - 1, true
 }
 
+// SetLook sets how the shape at x,y,z is drawn, nil resets it.
+func (loader *Loader) SetLook(x, y, z int, look *Look) {
+	section, atomX, atomY, atomZ := loader.getPosInSection(x, y, z)
+	if look == nil {
+		delete(section.looks, [3]int{atomX, atomY, atomZ})
+	} else {
+		section.looks[[3]int{atomX, atomY, atomZ}] = look
+	}
+}
+
+func (loader *Loader) GetLook(x, y, z int) *Look {
+	section, atomX, atomY, atomZ := loader.getPosInSection(x, y, z)
+	return section.looks[[3]int{atomX, atomY, atomZ}]
+}
+
+func (loader *Loader) SetExplored(x, y int) {
+	section, atomX, atomY, _ := loader.getPosInSection(x, y, 0)
+	section.explored[atomX][atomY] = true
+}
+
+func (loader *Loader) IsExplored(x, y int) bool {
+	section, atomX, atomY, _ := loader.getPosInSection(x, y, 0)
+	return section.explored[atomX][atomY]
+}
+
 func (loader *Loader) AddExtra(x, y, z int, shapeIndex int) bool {
 	section, atomX, atomY, atomZ := loader.getPosInSection(x, y, z)
 	section.extras[atomX][atomY][atomZ].Shapes = append(section.extras[atomX][atomY][atomZ].Shapes, shapeIndex)
@@ -237,9 +281,10 @@ func (loader *Loader) SaveAll() error {
 
 func (loader *Loader) load(sx, sy int) (*Section, error) {
 	section := &Section{
-		X:    sx,
-		Y:    sy,
-		data: map[string]interface{}{},
+		X:     sx,
+		Y:     sy,
+		data:  map[string]interface{}{},
+		looks: map[[3]int]*Look{},
 	}
 
 	mapName := mapFileName(sx, sy)
@@ -307,6 +352,22 @@ func (loader *Loader) load(sx, sy int) (*Section, error) {
 			fixArrays(data)
 			section.data = data
 		}
+		if version[0] >= 5 {
+			looks := []LookAt{}
+			err = dec.Decode(&looks)
+			if err != nil {
+				return nil, err
+			}
+			for i := range looks {
+				section.looks[[3]int{looks[i].X, looks[i].Y, looks[i].Z}] = &looks[i].Look
+			}
+		}
+		if version[0] >= 6 {
+			err = dec.Decode(&section.explored)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	return section, nil
 }
@@ -356,6 +417,18 @@ func (loader *Loader) save(section *Section) error {
 	if err != nil {
 		return err
 	}
+	looks := []LookAt{}
+	for pos, look := range section.looks {
+		looks = append(looks, LookAt{pos[0], pos[1], pos[2], *look})
+	}
+	err = enc.Encode(looks)
+	if err != nil {
+		return err
+	}
+	err = enc.Encode(section.explored)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }